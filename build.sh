@@ -3,4 +3,6 @@
 GIT_VERSION=$(git describe --always --abbrev=8  --dirty --broken)
 
 go version
-CGO_ENABLED=0 go build -ldflags "-X main.buildVersion=${GIT_VERSION}" cmd/spaceStatus/status2.go
+# cgo is needed for sqlite, the binary is still linked statically
+CGO_ENABLED=1 go build -tags "netgo osusergo sqlite_omit_load_extension" \
+  -ldflags "-X main.buildVersion=${GIT_VERSION} -linkmode external -extldflags -static" cmd/spaceStatus/status2.go
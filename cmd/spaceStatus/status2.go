@@ -1,6 +1,12 @@
 package main
 
 import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
 	"github.com/ktt-ol/status2/internal/events"
 	"github.com/ktt-ol/status2/internal/conf"
 	"github.com/ktt-ol/status2/internal/mqtt"
@@ -15,11 +21,60 @@ const CONFIG_FILE = "config.toml"
 
 var buildVersion = "unkown"
 
+const USAGE = `Usage:
+  status2                              starts the service
+  status2 replay [-speed n] <file>     starts the service, but feeds the recorded mqtt messages instead of using the broker
+  status2 db migrate                   applies all pending db schema migrations
+  status2 db export [options] [file]   writes spacestate or devices as csv or jsonl to the file (default: stdout)
+  status2 db import [options] <file>   reads spacestate or devices from a csv or jsonl file into the db
+
+Export and import options:
+  -table spacestate|devices  (default: spacestate)
+  -format csv|jsonl          (default: by the file extension, else csv)
+  -from, -to                 time range as YYYY-MM-DD or RFC3339, the end is exclusive
+  -place space|radstelle|lab3d|machining  only for spacestate
+  -dry-run                   import only, reports the invalid rows but doesn't write anything
+`
+
+const (
+	MODE_SERVICE    = "service"
+	MODE_REPLAY     = "replay"
+	MODE_DB_MIGRATE = "db migrate"
+	MODE_DB_EXPORT  = "db export"
+	MODE_DB_IMPORT  = "db import"
+)
+
+type cliArgs struct {
+	mode        string
+	replayFile  string
+	replaySpeed float64
+	// for export and import, an empty file is stdout
+	exchangeFile    string
+	exchangeOptions db.ExchangeOptions
+}
+
 func main() {
+	args := parseArgs(os.Args[1:])
+
 	config := conf.LoadConfig(CONFIG_FILE)
 
 	conf.SetupLogging(config.Misc)
 
+	if args.mode == MODE_DB_MIGRATE {
+		if err := db.Migrate(config.Db, config.MySql); err != nil {
+			logrus.WithError(err).Fatal("Migration failed.")
+		}
+		return
+	}
+	if args.mode == MODE_DB_EXPORT {
+		exportData(config, args)
+		return
+	}
+	if args.mode == MODE_DB_IMPORT {
+		importData(config, args)
+		return
+	}
+
 	logrus.Info("\n" +
 		"-------------\n" +
 		"Starting status2\n" +
@@ -29,12 +84,161 @@ func main() {
 	st := state.NewDefaultState()
 	ev := events.NewEventManager()
 
-	dbMgr := db.NewManager(config.MySql)
-	db.NewOpenStatePersistence(dbMgr, ev, st)
-	db.NewDevicePersistence(config.MySql, dbMgr, st)
+	dbMgr := db.NewManager(config.Db, config.MySql)
+
+	var mqttMgr *mqtt.MqttManager
+	if args.mode == MODE_SERVICE {
+		db.NewOpenStatePersistence(dbMgr, ev, st)
+		db.NewDevicePersistence(config.MySql, dbMgr, ev, st)
+		db.NewPowerPersistence(config.Db, dbMgr, ev, st)
+		db.NewDevicesRetention(config.Db, dbMgr)
+
+		twitter.NewTwitterHandler(config.Twitter, ev, st)
+		mqttMgr = mqtt.NewMqttManager(config.Mqtt, config.Keyholder, ev, st)
+	} else {
+		// no persistence and notifications for replayed data
+		logrus.Info("Replay mode: no db writes and no tweets.")
+		mqttMgr = mqtt.NewReplayMqttManager(config.Mqtt, config.Keyholder, ev, st)
+		go func() {
+			if err := mqttMgr.Replay(args.replayFile, args.replaySpeed); err != nil {
+				logrus.WithError(err).Error("Replay failed.")
+			}
+		}()
+	}
+
+	web.StartWebService(config.Web, config.Energy, config.Forecast, ev, st, dbMgr, mqttMgr)
+}
+
+func parseArgs(args []string) cliArgs {
+	if len(args) == 0 {
+		return cliArgs{mode: MODE_SERVICE}
+	}
+
+	switch args[0] {
+	case "replay":
+		replayFlags := flag.NewFlagSet("replay", flag.ExitOnError)
+		speed := replayFlags.Float64("speed", 1, "speed factor, e.g. 10 for 10x faster. 0 replays without any delay.")
+		replayFlags.Parse(args[1:])
+		if replayFlags.NArg() != 1 {
+			exitWithUsage()
+		}
+		return cliArgs{mode: MODE_REPLAY, replayFile: replayFlags.Arg(0), replaySpeed: *speed}
+	case "db":
+		if len(args) == 2 && args[1] == "migrate" {
+			return cliArgs{mode: MODE_DB_MIGRATE}
+		}
+		if len(args) >= 2 && (args[1] == "export" || args[1] == "import") {
+			return parseExchangeArgs(args[1], args[2:])
+		}
+	}
+
+	exitWithUsage()
+	return cliArgs{}
+}
+
+func parseExchangeArgs(command string, args []string) cliArgs {
+	exchangeFlags := flag.NewFlagSet(command, flag.ExitOnError)
+	table := exchangeFlags.String("table", db.TABLE_SPACESTATE, "spacestate or devices")
+	format := exchangeFlags.String("format", "", "csv or jsonl")
+	from := exchangeFlags.String("from", "", "the start, YYYY-MM-DD or RFC3339")
+	to := exchangeFlags.String("to", "", "the end (exclusive), YYYY-MM-DD or RFC3339")
+	place := exchangeFlags.String("place", "", "only this place")
+	dryRun := exchangeFlags.Bool("dry-run", false, "only checks the rows")
+	exchangeFlags.Parse(args)
+
+	result := cliArgs{mode: MODE_DB_EXPORT}
+	if command == "import" {
+		result.mode = MODE_DB_IMPORT
+		if exchangeFlags.NArg() != 1 {
+			exitWithUsage()
+		}
+	} else if exchangeFlags.NArg() > 1 || *dryRun {
+		exitWithUsage()
+	}
+	result.exchangeFile = exchangeFlags.Arg(0)
+
+	if *format == "" {
+		*format = db.FORMAT_CSV
+		if strings.HasSuffix(result.exchangeFile, ".jsonl") || strings.HasSuffix(result.exchangeFile, ".json") {
+			*format = db.FORMAT_JSONL
+		}
+	}
+
+	result.exchangeOptions = db.ExchangeOptions{Table: *table, Format: *format, Place: db.Place(*place), DryRun: *dryRun}
+	var err error
+	if result.exchangeOptions.From, err = parseCliTime(*from); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		exitWithUsage()
+	}
+	if result.exchangeOptions.To, err = parseCliTime(*to); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		exitWithUsage()
+	}
+
+	return result
+}
+
+// YYYY-MM-DD (local time) or RFC3339, empty is the zero time
+func parseCliTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if ts, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return ts, nil
+	}
+	if ts, err := time.Parse(time.RFC3339, value); err == nil {
+		return ts, nil
+	}
 
-	twitter.NewTwitterHandler(config.Twitter, ev, st)
-	mqttMgr := mqtt.NewMqttManager(config.Mqtt, ev, st)
+	return time.Time{}, fmt.Errorf("Invalid time: %s", value)
+}
+
+func exportData(config conf.TomlConfig, args cliArgs) {
+	out := os.Stdout
+	if args.exchangeFile == "" {
+		// keep the data clean
+		logrus.SetOutput(os.Stderr)
+	} else {
+		file, err := os.Create(args.exchangeFile)
+		if err != nil {
+			logrus.WithError(err).Fatal("Can't create the export file.")
+		}
+		defer file.Close()
+		out = file
+	}
+
+	count, err := db.Export(config.Db, config.MySql, args.exchangeOptions, out)
+	if err != nil {
+		logrus.WithError(err).Fatal("Export failed.")
+	}
+	logrus.WithField("rows", count).Info("Export done.")
+}
+
+func importData(config conf.TomlConfig, args cliArgs) {
+	file, err := os.Open(args.exchangeFile)
+	if err != nil {
+		logrus.WithError(err).Fatal("Can't open the import file.")
+	}
+	defer file.Close()
+
+	result, err := db.Import(config.Db, config.MySql, args.exchangeOptions, file)
+	for _, invalid := range result.Invalid {
+		fmt.Fprintf(os.Stderr, "line %d: %s\n", invalid.Line, invalid.Error)
+	}
+	if err != nil {
+		logrus.WithError(err).Fatal("Import failed.")
+	}
+
+	fields := logrus.Fields{"imported": result.Imported, "skipped": result.Skipped,
+		"duplicates": result.Duplicates, "rolledUp": result.RolledUp, "invalid": len(result.Invalid)}
+	if args.exchangeOptions.DryRun {
+		logrus.WithFields(fields).Info("Dry run done, nothing written.")
+	} else {
+		logrus.WithFields(fields).Info("Import done.")
+	}
+}
 
-	web.StartWebService(config.Web, ev, st, dbMgr, mqttMgr)
+func exitWithUsage() {
+	fmt.Fprint(os.Stderr, USAGE)
+	os.Exit(1)
 }
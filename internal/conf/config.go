@@ -16,11 +16,15 @@ func LoadConfig(configFile string) TomlConfig {
 }
 
 type TomlConfig struct {
-	Mqtt    MqttConf
-	MySql   MySqlConf
-	Twitter TwitterConf
-	Web     WebServiceConf
-	Misc    MiscConf
+	Mqtt      MqttConf
+	Keyholder KeyholderConf
+	Db        DbConf
+	MySql     MySqlConf
+	Energy    EnergyConf
+	Forecast  ForecastConf
+	Twitter   TwitterConf
+	Web       WebServiceConf
+	Misc      MiscConf
 }
 
 type MqttConf struct {
@@ -31,6 +35,10 @@ type MqttConf struct {
 	CertFile string
 
 	Topics MqttTopicsConf
+	// optional, how to decode the payload of a topic. The key is the topic, without an entry the raw payload is used.
+	Decoders map[string]PayloadDecoderConf
+	// optional, if set all received messages are appended to this file (one json per line), see "status2 replay"
+	RecordFile string
 }
 
 type MqttTopicsConf struct {
@@ -50,12 +58,45 @@ type MqttTopicsConf struct {
 
 	KeyholderId              string
 	KeyholderName            string
+	KeyholderIdMachining     string
 	KeyholderNameMachining   string
+	KeyholderIdWoodworking   string
 	KeyholderNameWoodworking string
 
 	BackdoorBoltContact string
 }
 
+type KeyholderConf struct {
+	// maps the keyholder id to the public display name, an empty name hides the keyholder
+	Aliases map[string]string
+	// if true, only keyholders with an alias are shown
+	HideUnknown bool
+}
+
+type PayloadDecoderConf struct {
+	// "raw" (default) or "json"
+	Type string
+	// only for json: dot separated path to the value, e.g. "power" or "ENERGY.Power"
+	Path string
+	// numeric values are multiplied with this factor, 0 means no scaling
+	Scale float64
+	// the unit of the (scaled) value, for power topics this is the source unit of the meter ("mW" (default), "W" or "kW")
+	Unit string
+	// only for json: optional path to the unit in the payload, takes precedence over Unit
+	UnitPath string
+}
+
+type DbConf struct {
+	Driver      string // "mysql" (default) or "sqlite"
+	SqliteFile  string
+	AutoMigrate bool   // applies pending schema migrations at startup
+	OutboxFile  string // stores the pending writes while the db is not available
+	// the power values are stored as average and max for this interval, 0 disables it
+	PowerSampleIntervalInSec int
+	// raw devices rows older than this are rolled up into hourly and daily values and deleted, 0 disables it
+	DevicesRetentionDays int
+}
+
 type MySqlConf struct {
 	Host                     string
 	User                     string
@@ -64,6 +105,18 @@ type MySqlConf struct {
 	SaveDevicesIntervalInSec int
 }
 
+type EnergyConf struct {
+	PricePerKWh float64
+	Currency    string
+}
+
+type ForecastConf struct {
+	// the weeks of history for the opening forecast, 0 uses the default (12)
+	LookbackWeeks int
+	// the weight of a week compared to the following one, e.g. 0.8. 0 uses the default (0.8), 1 weights all weeks equally
+	WeeklyDecay float64
+}
+
 type TwitterConf struct {
 	Mocking           bool // # if true, it does everthing except the actual tweet. Useful for developing.
 	Enabled           bool
@@ -79,6 +132,16 @@ type WebServiceConf struct {
 	Host           string
 	Port           int
 	SwitchPassword string // to change a status on the /switch page
+	// /readyz fails if the devices or power data is older, 0 uses the default (900)
+	ReadyMaxDataAgeInSec int
+	// user -> token for /api/state, no tokens disable the api
+	ApiTokens map[string]string
+	// optional, every state change via /api/state or /switch is appended as json line to this file
+	AuditLogFile string
+	// optional, the messages and until times of states set via /api/state are kept in this file to survive a restart
+	StateNotesFile string
+	// the reverse proxies whose X-Forwarded-For and X-Real-Ip headers are used for the client ip on the /switch page
+	TrustedProxies []string
 }
 
 type MiscConf struct {
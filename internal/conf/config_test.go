@@ -16,9 +16,13 @@ func Test_Config(t *testing.T) {
 	require.Equal(t, "/net/devices", config.Mqtt.Topics.Devices)
 	require.Equal(t, "/access-control-system/space-state", config.Mqtt.Topics.StateSpace)
 
+	require.Equal(t, "mysql", config.Db.Driver)
 	require.Equal(t, "localhost", config.MySql.Host)
 	require.Equal(t, 900, config.MySql.SaveDevicesIntervalInSec)
 
+	require.Equal(t, 0.30, config.Energy.PricePerKWh)
+	require.Equal(t, 12, config.Forecast.LookbackWeeks)
+
 	require.Equal(t, false, config.Twitter.Enabled)
 	require.Equal(t, 180, config.Twitter.TwitterdelayInSec)
 	require.Equal(t, "?", config.Twitter.AccessTokenKey)
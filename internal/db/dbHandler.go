@@ -1,6 +1,7 @@
 package db
 
 import (
+	"context"
 	"github.com/ktt-ol/status2/internal/conf"
 	"database/sql"
 	_ "github.com/go-sql-driver/mysql"
@@ -13,15 +14,33 @@ import (
 var logger = logrus.WithField("where", "db")
 
 type DbManager interface {
-	GetLastOpenStates() []LastOpenStates
-	GetLastDevicesData() *LastDevices
-	GetAllSpaceOpenStates() []OpenState
-	UpdateOpenState(place Place, openValue state.OpenValueTs)
-	UpdateDevicesAndPeople(devicesCount int64, peopleCount int64)
+	GetLastOpenStates() ([]LastOpenStates, error)
+	GetLastDevicesData() (*LastDevices, error)
+	// The states of the place in [from, to), ordered by time. The first entry is the state that was active at from
+	// (with its original time before from), if there is one. Closing is returned as open.
+	GetOpenStates(place Place, from time.Time, to time.Time) ([]OpenState, error)
+	// like GetOpenStates, but calls the handler for every state instead of loading all into memory
+	ForEachOpenState(place Place, from time.Time, to time.Time, handler func(openState OpenState) error) error
+	UpdateOpenState(place Place, openValue state.OpenValueTs) error
+	UpdateDevices(sample DevicesSample) error
+	// the min, avg and max people and devices per period in [from, to), see dbManager.GetDevicesStats
+	GetDevicesStats(from time.Time, to time.Time, resolution Resolution) ([]DevicesStat, error)
+	// rolls the raw devices rows of the days before the given time up and deletes them, returns the rolled up days
+	RollupDevices(before time.Time) (int, error)
+	// the samples between from (inclusive) and to (exclusive), ordered by time; an empty meter returns all meters
+	GetPowerSamples(meter state.PowerMeter, from time.Time, to time.Time) ([]PowerSample, error)
+	// like GetPowerSamples, but calls the handler for every sample instead of loading all into memory
+	ForEachPowerSample(meter state.PowerMeter, from time.Time, to time.Time, handler func(sample PowerSample) error) error
+	UpdatePower(sample PowerSample) error
+	Status() DbStatus
+	// checks that the db is reachable, independent of the queued writes
+	Ping() error
 }
 
+// All times are stored in UTC, sqlite compares them as strings.
 type dbManager struct {
-	db *sql.DB
+	db     *sql.DB
+	driver string
 }
 
 type LastOpenStates struct {
@@ -30,9 +49,26 @@ type LastOpenStates struct {
 }
 
 type LastDevices struct {
-	Devices   int64
-	People    int64
-	Timestamp time.Time
+	Devices        int64
+	People         int64
+	UnknownDevices int64
+	Timestamp      time.Time
+}
+
+// The devices and people at a time, in total and per location.
+type DevicesSample struct {
+	Timestamp      time.Time       `json:"timestamp"`
+	Devices        int64           `json:"devices"`
+	People         int64           `json:"people"`
+	UnknownDevices int64           `json:"unknownDevices"`
+	Locations      []LocationCount `json:"locations,omitempty"`
+}
+
+// The known devices and their people at a location, ordered by the location name.
+type LocationCount struct {
+	Location string `json:"location"`
+	Devices  int64  `json:"devices"`
+	People   int64  `json:"people"`
 }
 
 type OpenState struct {
@@ -40,24 +76,84 @@ type OpenState struct {
 	Time  time.Time
 }
 
-func NewManager(config conf.MySqlConf) DbManager {
-	connectionString := fmt.Sprintf("%s:%s@tcp(%s)/%s?charset=utf8&parseTime=true", config.User, config.Password, config.Host, config.Database)
+// The aggregated power values of a meter for the sample interval, starting at Timestamp.
+type PowerSample struct {
+	Meter     state.PowerMeter `json:"meter"`
+	Timestamp time.Time        `json:"timestamp"`
+	Avg       float64          `json:"avg"`
+	Max       float64          `json:"max"`
+	Samples   int              `json:"samples"`
+	// the length of the sample interval
+	DurationInSec int `json:"durationInSec"`
+}
+
+type DbStatus struct {
+	// true if the last db access failed, the writes are queued in the outbox
+	Degraded      bool   `json:"degraded"`
+	PendingWrites int    `json:"pendingWrites"`
+	LastError     string `json:"lastError,omitempty"`
+}
+
+const (
+	DRIVER_MYSQL  = "mysql"
+	DRIVER_SQLITE = "sqlite"
+)
+
+const PING_TIMEOUT = 3 * time.Second
+
+// Creates the manager for the configured driver, default is mysql. The schema is migrated if autoMigrate is set,
+// otherwise the schema version must match the code. All writes go through the outbox.
+func NewManager(config conf.DbConf, mysqlConfig conf.MySqlConf) DbManager {
+	db := openManager(config, mysqlConfig)
+	if config.AutoMigrate {
+		if err := db.migrate(); err != nil {
+			logger.WithError(err).Fatal("Can't migrate the db schema.")
+		}
+	} else {
+		db.checkSchemaVersion()
+	}
+
+	return newOutbox(db, config.OutboxFile)
+}
+
+// Applies all pending schema migrations, used by "status2 db migrate".
+func Migrate(config conf.DbConf, mysqlConfig conf.MySqlConf) error {
+	db := openManager(config, mysqlConfig)
+	defer db.db.Close()
+
+	return db.migrate()
+}
+
+func openManager(config conf.DbConf, mysqlConfig conf.MySqlConf) *dbManager {
+	switch config.Driver {
+	case "", DRIVER_MYSQL:
+		return newMySqlManager(mysqlConfig)
+	case DRIVER_SQLITE:
+		return newSqliteManager(config.SqliteFile)
+	default:
+		logger.WithField("driver", config.Driver).Fatal("Unknown db driver.")
+		return nil
+	}
+}
+
+func newMySqlManager(config conf.MySqlConf) *dbManager {
+	connectionString := fmt.Sprintf("%s:%s@tcp(%s)/%s?charset=utf8mb4&parseTime=true", config.User, config.Password, config.Host, config.Database)
 	db, err := sql.Open("mysql", connectionString)
 	if err != nil {
 		logger.Fatal("Can't connect to db.", err)
 	}
 
-	return &dbManager{db: db}
+	return &dbManager{db: db, driver: DRIVER_MYSQL}
 }
 
-func (db *dbManager) GetLastOpenStates() []LastOpenStates {
+func (db *dbManager) GetLastOpenStates() ([]LastOpenStates, error) {
 	const stmt = `SELECT place, state, timestamp FROM spacestate a
 	inner join (SELECT max(id) as id FROM spacestate group by place) m
 	on a.id = m.id`
 
 	rows, err := db.db.Query(stmt)
 	if err != nil {
-		logger.Fatal("Error during select", err)
+		return nil, err
 	}
 	defer rows.Close()
 
@@ -67,82 +163,193 @@ func (db *dbManager) GetLastOpenStates() []LastOpenStates {
 		var openValueStr string
 		var ts time.Time
 
-		err = rows.Scan(&place, &openValueStr, &ts)
-		basicErrorCheck(err)
+		if err := rows.Scan(&place, &openValueStr, &ts); err != nil {
+			return nil, err
+		}
 		openValue, err := state.ParseOpenValue(openValueStr)
-		basicErrorCheck(err)
+		if err != nil {
+			return nil, err
+		}
 
 		los := LastOpenStates{Place: place, State: state.OpenValueTs{Value: openValue, Timestamp: ts.Unix()}}
 		states = append(states, los)
 	}
 
-	return states
+	return states, rows.Err()
 }
 
-func (db *dbManager) GetLastDevicesData() *LastDevices {
-	const stmt = `select devices, people, ts from devices order by ts desc limit 1`
+func (db *dbManager) GetLastDevicesData() (*LastDevices, error) {
+	const stmt = `select devices, people, unknown_devices, ts from devices order by ts desc limit 1`
 
 	ld := LastDevices{}
-	err := db.db.QueryRow(stmt).Scan(&ld.Devices, &ld.People, &ld.Timestamp)
+	err := db.db.QueryRow(stmt).Scan(&ld.Devices, &ld.People, &ld.UnknownDevices, &ld.Timestamp)
 	if err != nil {
-		logger.Fatal(err)
+		return nil, err
 	}
 
-	return &ld
+	return &ld, nil
 }
 
-func (db *dbManager) GetAllSpaceOpenStates() []OpenState {
-	//const stmt = "SELECT state, timestamp FROM spacestate where place = 'space' and `timestamp` > '2016-12-30' and `timestamp` < '2017-01-05' ORDER BY id asc"
-	const stmt = "SELECT state, timestamp FROM spacestate where place = 'space' ORDER BY id asc"
+func (db *dbManager) GetOpenStates(place Place, from time.Time, to time.Time) ([]OpenState, error) {
+	result := make([]OpenState, 0, 100)
+	err := db.ForEachOpenState(place, from, to, func(openState OpenState) error {
+		result = append(result, openState)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
 
-	rows, err := db.db.Query(stmt)
-	basicErrorCheck(err)
+	return result, nil
+}
+
+func (db *dbManager) ForEachOpenState(place Place, from time.Time, to time.Time, handler func(openState OpenState) error) error {
+	const beforeStmt = "SELECT state, timestamp FROM spacestate WHERE place = ? AND timestamp < ? ORDER BY timestamp DESC, id DESC LIMIT 1"
+	const rangeStmt = "SELECT state, timestamp FROM spacestate WHERE place = ? AND timestamp >= ? AND timestamp < ? ORDER BY timestamp, id"
+
+	var openValueStr string
+	var ts time.Time
+	err := db.db.QueryRow(beforeStmt, place, from.UTC()).Scan(&openValueStr, &ts)
+	switch {
+	case err == sql.ErrNoRows:
+		// nothing before from
+	case err != nil:
+		return err
+	default:
+		if openState, ok := toOpenState(openValueStr, ts); ok {
+			if err := handler(openState); err != nil {
+				return err
+			}
+		}
+	}
+
+	rows, err := db.db.Query(rangeStmt, place, from.UTC(), to.UTC())
+	if err != nil {
+		return err
+	}
 	defer rows.Close()
 
-	result := make([]OpenState, 0, 100)
 	for rows.Next() {
-		var openValueStr string
-		var ts time.Time
-
-		err = rows.Scan(&openValueStr, &ts)
-		basicErrorCheck(err)
-		if openValueStr == "closing" {
-			openValueStr = "open"
+		if err := rows.Scan(&openValueStr, &ts); err != nil {
+			return err
 		}
-		openValue, err := state.ParseOpenValue(openValueStr)
-		if err != nil {
-			//logger.WithField("value", openValueStr).Debug("Ignoring open value")
+		openState, ok := toOpenState(openValueStr, ts)
+		if !ok {
 			continue
 		}
+		if err := handler(openState); err != nil {
+			return err
+		}
+	}
 
-		result = append(result, OpenState{openValue, ts})
+	return rows.Err()
+}
+
+// returns false for unknown values
+func toOpenState(openValueStr string, ts time.Time) (OpenState, bool) {
+	if openValueStr == "closing" {
+		openValueStr = "open"
 	}
+	openValue, err := state.ParseOpenValue(openValueStr)
+	if err != nil {
+		//logger.WithField("value", openValueStr).Debug("Ignoring open value")
+		return OpenState{}, false
+	}
+
+	return OpenState{openValue, ts}, true
+}
 
-	return result
+func (db *dbManager) UpdateOpenState(place Place, openValue state.OpenValueTs) error {
+	_, err := db.db.Exec("INSERT INTO spacestate (state, place, timestamp) VALUES (?, ?, ?)",
+		openValue.Value, place, time.Unix(openValue.Timestamp, 0).UTC())
+	return err
 }
 
-func (db *dbManager) UpdateOpenState(place Place, openValue state.OpenValueTs) {
-	//if !IsValidPlace(place) {
-	//	logger.WithField("place", place).Fatal("Invalid place.")
-	//}
+func (db *dbManager) UpdateDevices(sample DevicesSample) error {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
 
-	stmt, err := db.db.Prepare("INSERT INTO spacestate (state, place, timestamp) VALUES (?, ?, ?)")
-	basicErrorCheck(err)
+	result, err := tx.Exec("INSERT INTO devices (devices, people, unknown_devices, ts) VALUES (?, ?, ?, ?)",
+		sample.Devices, sample.People, sample.UnknownDevices, sample.Timestamp.UTC())
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	devicesId, err := result.LastInsertId()
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
 
-	_, err = stmt.Exec(openValue.Value, place, time.Unix(openValue.Timestamp, 0))
-	basicErrorCheck(err)
+	for _, location := range sample.Locations {
+		_, err := tx.Exec("INSERT INTO devices_location (devices_id, location, devices, people) VALUES (?, ?, ?, ?)",
+			devicesId, location.Location, location.Devices, location.People)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
 }
 
-func (db *dbManager) UpdateDevicesAndPeople(devicesCount int64, peopleCount int64) {
-	stmt, err := db.db.Prepare("INSERT INTO devices (devices, people, ts) VALUES (?, ?, NOW())")
-	basicErrorCheck(err)
+func (db *dbManager) GetPowerSamples(meter state.PowerMeter, from time.Time, to time.Time) ([]PowerSample, error) {
+	result := make([]PowerSample, 0, 100)
+	err := db.ForEachPowerSample(meter, from, to, func(sample PowerSample) error {
+		result = append(result, sample)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
 
-	_, err = stmt.Exec(devicesCount, peopleCount)
-	basicErrorCheck(err)
+	return result, nil
 }
 
-func basicErrorCheck(err error) {
+func (db *dbManager) ForEachPowerSample(meter state.PowerMeter, from time.Time, to time.Time, handler func(sample PowerSample) error) error {
+	stmt := "SELECT meter, ts, avg, max, samples, duration FROM power WHERE ts >= ? AND ts < ?"
+	args := []interface{}{from.UTC(), to.UTC()}
+	if meter != "" {
+		stmt += " AND meter = ?"
+		args = append(args, meter)
+	}
+	stmt += " ORDER BY ts, meter"
+
+	rows, err := db.db.Query(stmt, args...)
 	if err != nil {
-		logger.Fatal(err)
+		return err
 	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var sample PowerSample
+		err := rows.Scan(&sample.Meter, &sample.Timestamp, &sample.Avg, &sample.Max, &sample.Samples, &sample.DurationInSec)
+		if err != nil {
+			return err
+		}
+		if err := handler(sample); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+func (db *dbManager) UpdatePower(sample PowerSample) error {
+	_, err := db.db.Exec("INSERT INTO power (meter, ts, avg, max, samples, duration) VALUES (?, ?, ?, ?, ?, ?)",
+		sample.Meter, sample.Timestamp.UTC(), sample.Avg, sample.Max, sample.Samples, sample.DurationInSec)
+	return err
+}
+
+// The direct db access never queues anything.
+func (db *dbManager) Status() DbStatus {
+	return DbStatus{}
+}
+
+func (db *dbManager) Ping() error {
+	ctx, cancel := context.WithTimeout(context.Background(), PING_TIMEOUT)
+	defer cancel()
+	return db.db.PingContext(ctx)
 }
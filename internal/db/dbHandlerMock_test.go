@@ -1,6 +1,10 @@
 package db
 
-import "github.com/ktt-ol/status2/internal/state"
+import (
+	"time"
+
+	"github.com/ktt-ol/status2/internal/state"
+)
 
 type DbManagerMock struct {
 	LastOpenStatesValues []LastOpenStates
@@ -12,28 +16,70 @@ type DbManagerMock struct {
 	UpdateDevicesAndPeopleCount int
 	LastDevicesCount            int64
 	LastPeopleCount             int64
+	LastDevicesSample           DevicesSample
+
+	PowerSamples []PowerSample
+
+	RollupDevicesCount int
 }
 
-func (dbm *DbManagerMock) GetLastOpenStates() []LastOpenStates {
-	return dbm.LastOpenStatesValues
+func (dbm *DbManagerMock) GetLastOpenStates() ([]LastOpenStates, error) {
+	return dbm.LastOpenStatesValues, nil
+}
+
+func (dbm *DbManagerMock) GetLastDevicesData() (*LastDevices, error) {
+	panic("implement me")
 }
 
-func (dbm *DbManagerMock) GetLastDevicesData() *LastDevices {
+func (dbm *DbManagerMock) GetOpenStates(place Place, from time.Time, to time.Time) ([]OpenState, error) {
 	panic("implement me")
 }
 
-func (dbm *DbManagerMock) GetAllSpaceOpenStates() []OpenState {
+func (dbm *DbManagerMock) ForEachOpenState(place Place, from time.Time, to time.Time, handler func(openState OpenState) error) error {
 	panic("implement me")
 }
 
-func (dbm *DbManagerMock) UpdateOpenState(place Place, openValue state.OpenValueTs) {
+func (dbm *DbManagerMock) UpdateOpenState(place Place, openValue state.OpenValueTs) error {
 	dbm.UpdateOpenStateCount++
 	dbm.LastPlace = place
 	dbm.LastOpenValue = openValue
+	return nil
 }
 
-func (dbm *DbManagerMock) UpdateDevicesAndPeople(devicesCount int64, peopleCount int64) {
+func (dbm *DbManagerMock) UpdateDevices(sample DevicesSample) error {
 	dbm.UpdateDevicesAndPeopleCount++
-	dbm.LastDevicesCount = devicesCount
-	dbm.LastPeopleCount = peopleCount
+	dbm.LastDevicesCount = sample.Devices
+	dbm.LastPeopleCount = sample.People
+	dbm.LastDevicesSample = sample
+	return nil
+}
+
+func (dbm *DbManagerMock) GetDevicesStats(from time.Time, to time.Time, resolution Resolution) ([]DevicesStat, error) {
+	panic("implement me")
+}
+
+func (dbm *DbManagerMock) RollupDevices(before time.Time) (int, error) {
+	dbm.RollupDevicesCount++
+	return 0, nil
+}
+
+func (dbm *DbManagerMock) GetPowerSamples(meter state.PowerMeter, from time.Time, to time.Time) ([]PowerSample, error) {
+	panic("implement me")
+}
+
+func (dbm *DbManagerMock) ForEachPowerSample(meter state.PowerMeter, from time.Time, to time.Time, handler func(sample PowerSample) error) error {
+	panic("implement me")
+}
+
+func (dbm *DbManagerMock) UpdatePower(sample PowerSample) error {
+	dbm.PowerSamples = append(dbm.PowerSamples, sample)
+	return nil
+}
+
+func (dbm *DbManagerMock) Status() DbStatus {
+	return DbStatus{}
+}
+
+func (dbm *DbManagerMock) Ping() error {
+	return nil
 }
@@ -0,0 +1,139 @@
+package db
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ktt-ol/status2/internal/conf"
+	"github.com/ktt-ol/status2/internal/state"
+	"github.com/stretchr/testify/require"
+)
+
+// Creates a manager with an empty sqlite db in a temp folder. Call the returned func to remove it.
+func newTestManager(t *testing.T) (*dbManager, func()) {
+	dir, err := ioutil.TempDir("", "status2-db")
+	require.Nil(t, err)
+
+	mgr := openManager(conf.DbConf{Driver: DRIVER_SQLITE, SqliteFile: filepath.Join(dir, "test.sqlite")}, conf.MySqlConf{})
+	require.Nil(t, mgr.migrate())
+	return mgr, func() {
+		mgr.db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func Test_OpenStates(t *testing.T) {
+	mgr, cleanup := newTestManager(t)
+	defer cleanup()
+
+	last, err := mgr.GetLastOpenStates()
+	require.Nil(t, err)
+	require.Len(t, last, 0)
+	all, err := mgr.GetOpenStates(PLACE_SPACE, time.Unix(0, 0), time.Now())
+	require.Nil(t, err)
+	require.Len(t, all, 0)
+
+	require.Nil(t, mgr.UpdateOpenState(PLACE_SPACE, state.OpenValueTs{Value: state.OPEN, Timestamp: 1000}))
+	require.Nil(t, mgr.UpdateOpenState(PLACE_RADSTELLE, state.OpenValueTs{Value: state.MEMBER, Timestamp: 1100}))
+	require.Nil(t, mgr.UpdateOpenState(PLACE_SPACE, state.OpenValueTs{Value: state.CLOSING, Timestamp: 1200}))
+	require.Nil(t, mgr.UpdateOpenState(PLACE_SPACE, state.OpenValueTs{Value: state.NONE, Timestamp: 1300}))
+
+	last, err = mgr.GetLastOpenStates()
+	require.Nil(t, err)
+	require.Len(t, last, 2)
+	byPlace := make(map[Place]state.OpenValueTs)
+	for _, los := range last {
+		byPlace[los.Place] = los.State
+	}
+	require.Equal(t, state.OpenValueTs{Value: state.NONE, Timestamp: 1300}, byPlace[PLACE_SPACE])
+	require.Equal(t, state.OpenValueTs{Value: state.MEMBER, Timestamp: 1100}, byPlace[PLACE_RADSTELLE])
+
+	// only the space, closing is counted as open
+	all, err = mgr.GetOpenStates(PLACE_SPACE, time.Unix(0, 0), time.Now())
+	require.Nil(t, err)
+	require.Len(t, all, 3)
+	require.Equal(t, state.OPEN, all[0].Value)
+	require.Equal(t, int64(1000), all[0].Time.Unix())
+	require.Equal(t, state.OPEN, all[1].Value)
+	require.Equal(t, state.NONE, all[2].Value)
+	require.Equal(t, int64(1300), all[2].Time.Unix())
+
+	// starts with the active state before from, to is exclusive
+	all, err = mgr.GetOpenStates(PLACE_SPACE, time.Unix(1100, 0), time.Unix(1300, 0))
+	require.Nil(t, err)
+	require.Equal(t, []OpenState{{state.OPEN, time.Unix(1000, 0).UTC()}, {state.OPEN, time.Unix(1200, 0).UTC()}}, all)
+
+	all, err = mgr.GetOpenStates(PLACE_RADSTELLE, time.Unix(2000, 0), time.Unix(3000, 0))
+	require.Nil(t, err)
+	require.Equal(t, []OpenState{{state.MEMBER, time.Unix(1100, 0).UTC()}}, all)
+
+	// the handler can stop the iteration
+	stopErr := errors.New("stop")
+	count := 0
+	err = mgr.ForEachOpenState(PLACE_SPACE, time.Unix(0, 0), time.Now(), func(openState OpenState) error {
+		count++
+		return stopErr
+	})
+	require.Equal(t, stopErr, err)
+	require.Equal(t, 1, count)
+}
+
+func Test_Devices(t *testing.T) {
+	mgr, cleanup := newTestManager(t)
+	defer cleanup()
+
+	_, err := mgr.GetLastDevicesData()
+	require.NotNil(t, err)
+
+	ts := time.Unix(1500, 0)
+	require.Nil(t, mgr.UpdateDevices(DevicesSample{Timestamp: ts, Devices: 10, People: 3, UnknownDevices: 4,
+		Locations: []LocationCount{{Location: "lab", Devices: 2, People: 1}, {Location: "space", Devices: 4, People: 2}}}))
+	last, err := mgr.GetLastDevicesData()
+	require.Nil(t, err)
+	require.Equal(t, int64(10), last.Devices)
+	require.Equal(t, int64(3), last.People)
+	require.Equal(t, int64(4), last.UnknownDevices)
+	require.Equal(t, ts.Unix(), last.Timestamp.Unix())
+
+	var count int
+	require.Nil(t, mgr.db.QueryRow("SELECT count(*) FROM devices_location").Scan(&count))
+	require.Equal(t, 2, count)
+}
+
+func Test_Power(t *testing.T) {
+	mgr, cleanup := newTestManager(t)
+	defer cleanup()
+
+	start := time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		ts := start.Add(time.Duration(i) * time.Minute)
+		require.Nil(t, mgr.UpdatePower(PowerSample{Meter: state.POWER_METER_FRONT, Timestamp: ts, Avg: float64(i), Max: 10, Samples: 2, DurationInSec: 60}))
+		// stored in another time zone
+		require.Nil(t, mgr.UpdatePower(PowerSample{Meter: state.POWER_METER_BACK, Timestamp: ts.In(time.FixedZone("X", 3600)), Avg: 5, Max: 6, Samples: 1}))
+	}
+
+	samples, err := mgr.GetPowerSamples("", start, start.Add(2*time.Minute))
+	require.Nil(t, err)
+	require.Len(t, samples, 4)
+	require.Equal(t, state.POWER_METER_BACK, samples[0].Meter)
+	require.Equal(t, start.Unix(), samples[0].Timestamp.Unix())
+
+	samples, err = mgr.GetPowerSamples(state.POWER_METER_FRONT, start.Add(time.Minute), start.Add(time.Hour))
+	require.Nil(t, err)
+	require.Len(t, samples, 2)
+	require.Equal(t, PowerSample{Meter: state.POWER_METER_FRONT, Timestamp: start.Add(time.Minute), Avg: 1, Max: 10, Samples: 2, DurationInSec: 60}, samples[0])
+}
+
+func Test_Errors(t *testing.T) {
+	mgr, cleanup := newTestManager(t)
+	cleanup()
+
+	// no fatal exit
+	_, err := mgr.GetLastOpenStates()
+	require.NotNil(t, err)
+	require.NotNil(t, mgr.UpdateOpenState(PLACE_SPACE, state.OpenValueTs{Value: state.OPEN, Timestamp: 1000}))
+}
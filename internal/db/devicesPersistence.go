@@ -1,27 +1,71 @@
 package db
 
 import (
-	"github.com/ktt-ol/status2/internal/state"
+	"github.com/ktt-ol/spaceDevices/pkg/structs"
 	"github.com/ktt-ol/status2/internal/conf"
+	"github.com/ktt-ol/status2/internal/events"
+	"github.com/ktt-ol/status2/internal/state"
+	"reflect"
+	"sort"
+	"sync"
 	"time"
 )
 
+// Stores the devices data on every change. If nothing changed, the data is stored once per interval as heartbeat.
 type DevicePersistence struct {
 	dbManager     DbManager
 	st            *state.State
 	timerInterval time.Duration
 	stopChan      chan bool
 	ticker        *time.Ticker
+
+	lock       sync.Mutex
+	lastSample *DevicesSample
+	// true if a change was stored since the last tick
+	changeSaved bool
 }
 
-func NewDevicePersistence(config conf.MySqlConf, dbManager DbManager, st *state.State) *DevicePersistence {
-	dp := DevicePersistence{dbManager, st,
-		time.Duration(config.SaveDevicesIntervalInSec) * time.Second,
-		make(chan bool), nil}
+func NewDevicePersistence(config conf.MySqlConf, dbManager DbManager, ev events.EventManager, st *state.State) *DevicePersistence {
+	dp := DevicePersistence{dbManager: dbManager, st: st,
+		timerInterval: time.Duration(config.SaveDevicesIntervalInSec) * time.Second,
+		stopChan:      make(chan bool)}
+	ev.On(events.TOPIC_SPACE_DEVICES, dp.onChange)
 	dp.startTimer()
 	return &dp
 }
 
+func (dp *DevicePersistence) onChange(topic events.EventName) {
+	dp.lock.Lock()
+	defer dp.lock.Unlock()
+
+	sample := NewDevicesSample(dp.st.SpaceDevices.PeopleAndDevices, time.Now())
+	if dp.lastSample != nil && sample.sameCounts(dp.lastSample) {
+		return
+	}
+
+	dp.save(sample)
+	dp.changeSaved = true
+}
+
+func (dp *DevicePersistence) onHeartbeat() {
+	dp.lock.Lock()
+	defer dp.lock.Unlock()
+
+	if dp.changeSaved {
+		dp.changeSaved = false
+		return
+	}
+
+	dp.save(NewDevicesSample(dp.st.SpaceDevices.PeopleAndDevices, time.Now()))
+}
+
+func (dp *DevicePersistence) save(sample DevicesSample) {
+	if err := dp.dbManager.UpdateDevices(sample); err != nil {
+		logger.WithError(err).Error("Can't update the devices.")
+	}
+	dp.lastSample = &sample
+}
+
 func (dp *DevicePersistence) startTimer() {
 	logger.Info("Starting DevicePersistence timer.")
 	dp.ticker = time.NewTicker(dp.timerInterval)
@@ -29,7 +73,7 @@ func (dp *DevicePersistence) startTimer() {
 		for {
 			select {
 			case <-dp.ticker.C:
-				dp.dbManager.UpdateDevicesAndPeople(int64(dp.st.SpaceDevices.DeviceCount), int64(dp.st.SpaceDevices.PeopleCount))
+				dp.onHeartbeat()
 			case <-dp.stopChan:
 				return
 			}
@@ -44,3 +88,48 @@ func (dp *DevicePersistence) StopTimer() {
 		dp.stopChan <- true
 	}
 }
+
+// Counts the known devices and people per location. A person is counted at every location with one of its devices.
+func NewDevicesSample(devices structs.PeopleAndDevices, ts time.Time) DevicesSample {
+	sample := DevicesSample{
+		Timestamp:      ts,
+		Devices:        int64(devices.DeviceCount),
+		People:         int64(devices.PeopleCount),
+		UnknownDevices: int64(devices.UnknownDevicesCount),
+	}
+
+	byLocation := make(map[string]*LocationCount)
+	for _, person := range devices.People {
+		personLocations := make(map[string]bool)
+		for _, device := range person.Devices {
+			if device.Location == "" {
+				continue
+			}
+			count, ok := byLocation[device.Location]
+			if !ok {
+				count = &LocationCount{Location: device.Location}
+				byLocation[device.Location] = count
+			}
+			count.Devices++
+			if !personLocations[device.Location] {
+				personLocations[device.Location] = true
+				count.People++
+			}
+		}
+	}
+
+	for _, count := range byLocation {
+		sample.Locations = append(sample.Locations, *count)
+	}
+	sort.Slice(sample.Locations, func(i, j int) bool {
+		return sample.Locations[i].Location < sample.Locations[j].Location
+	})
+
+	return sample
+}
+
+// true if everything except the time is the same
+func (s *DevicesSample) sameCounts(other *DevicesSample) bool {
+	return s.Devices == other.Devices && s.People == other.People && s.UnknownDevices == other.UnknownDevices &&
+		reflect.DeepEqual(s.Locations, other.Locations)
+}
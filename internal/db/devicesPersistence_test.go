@@ -6,6 +6,8 @@ import (
 	"github.com/stretchr/testify/require"
 	"github.com/ktt-ol/status2/internal/conf"
 	"time"
+	"github.com/ktt-ol/status2/internal/events"
+	"github.com/ktt-ol/spaceDevices/pkg/structs"
 )
 
 func Test_DevicePersistence(t *testing.T) {
@@ -16,7 +18,7 @@ func Test_DevicePersistence(t *testing.T) {
 	appState.SpaceDevices.DeviceCount = 10
 	appState.SpaceDevices.PeopleCount = 2
 
-	dp := NewDevicePersistence(dbConf, dbMock, appState)
+	dp := NewDevicePersistence(dbConf, dbMock, events.NewEventManager(), appState)
 	require.Equal(t, 0, dbMock.UpdateDevicesAndPeopleCount)
 
 	waitingTime := 1010
@@ -49,3 +51,59 @@ func Test_DevicePersistence(t *testing.T) {
 	require.Equal(t, int64(11), dbMock.LastDevicesCount)
 	require.Equal(t, int64(3), dbMock.LastPeopleCount)
 }
+
+func Test_DevicePersistenceOnChange(t *testing.T) {
+	dbConf := conf.MySqlConf{SaveDevicesIntervalInSec: 1}
+	dbMock := new(DbManagerMock)
+	ev := events.NewEventManager()
+	appState := state.NewDefaultState()
+
+	dp := NewDevicePersistence(dbConf, dbMock, ev, appState)
+	defer dp.StopTimer()
+
+	appState.SpaceDevices.DeviceCount = 10
+	ev.Emit(events.TOPIC_SPACE_DEVICES)
+	require.Equal(t, 1, dbMock.UpdateDevicesAndPeopleCount)
+	require.Equal(t, int64(10), dbMock.LastDevicesCount)
+
+	// nothing changed
+	ev.Emit(events.TOPIC_SPACE_DEVICES)
+	require.Equal(t, 1, dbMock.UpdateDevicesAndPeopleCount)
+
+	appState.SpaceDevices.UnknownDevicesCount = 3
+	ev.Emit(events.TOPIC_SPACE_DEVICES)
+	require.Equal(t, 2, dbMock.UpdateDevicesAndPeopleCount)
+	require.Equal(t, int64(3), dbMock.LastDevicesSample.UnknownDevices)
+
+	// no heartbeat after a change
+	time.Sleep(1010 * time.Millisecond)
+	require.Equal(t, 2, dbMock.UpdateDevicesAndPeopleCount)
+	// but after a full interval without a change
+	time.Sleep(1050 * time.Millisecond)
+	require.Equal(t, 3, dbMock.UpdateDevicesAndPeopleCount)
+}
+
+func Test_NewDevicesSample(t *testing.T) {
+	ts := time.Unix(1000, 0)
+	devices := structs.PeopleAndDevices{
+		People: []structs.Person{
+			{Name: "a", Devices: []structs.Device{{Name: "phone", Location: "space"}, {Name: "laptop", Location: "space"}}},
+			{Name: "b", Devices: []structs.Device{{Name: "phone", Location: "lab"}, {Name: "laptop", Location: "space"}, {Name: "x"}}},
+		},
+		PeopleCount:         2,
+		DeviceCount:         8,
+		UnknownDevicesCount: 3,
+	}
+
+	sample := NewDevicesSample(devices, ts)
+	require.Equal(t, DevicesSample{Timestamp: ts, Devices: 8, People: 2, UnknownDevices: 3, Locations: []LocationCount{
+		{Location: "lab", Devices: 1, People: 1},
+		{Location: "space", Devices: 3, People: 2},
+	}}, sample)
+
+	other := NewDevicesSample(devices, ts.Add(time.Minute))
+	require.True(t, sample.sameCounts(&other))
+	devices.People = devices.People[:1]
+	other = NewDevicesSample(devices, ts)
+	require.False(t, sample.sameCounts(&other))
+}
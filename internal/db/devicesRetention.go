@@ -0,0 +1,73 @@
+package db
+
+import (
+	"time"
+
+	"github.com/ktt-ol/status2/internal/conf"
+)
+
+const retentionInterval = time.Hour
+
+// Rolls the raw devices rows older than the retention up into hourly and daily values, once at the start and then
+// every hour.
+type DevicesRetention struct {
+	dbManager DbManager
+	retention time.Duration
+	now       func() time.Time
+
+	stopChan chan bool
+	ticker   *time.Ticker
+}
+
+// Returns nil if the retention is disabled.
+func NewDevicesRetention(config conf.DbConf, dbManager DbManager) *DevicesRetention {
+	if config.DevicesRetentionDays <= 0 {
+		logger.Info("Devices retention is disabled.")
+		return nil
+	}
+
+	dr := &DevicesRetention{
+		dbManager: dbManager,
+		retention: time.Duration(config.DevicesRetentionDays) * 24 * time.Hour,
+		now:       time.Now,
+		stopChan:  make(chan bool),
+	}
+	dr.startTimer()
+	return dr
+}
+
+func (dr *DevicesRetention) rollup() {
+	before := dr.now().Add(-dr.retention)
+	days, err := dr.dbManager.RollupDevices(before)
+	if err != nil {
+		logger.WithError(err).Error("Can't roll up the devices.")
+		return
+	}
+	if days > 0 {
+		logger.WithField("days", days).Info("Rolled up the devices.")
+	}
+}
+
+func (dr *DevicesRetention) startTimer() {
+	logger.WithField("retention", dr.retention).Info("Starting DevicesRetention timer.")
+	dr.ticker = time.NewTicker(retentionInterval)
+	go func() {
+		dr.rollup()
+		for {
+			select {
+			case <-dr.ticker.C:
+				dr.rollup()
+			case <-dr.stopChan:
+				return
+			}
+		}
+	}()
+}
+
+func (dr *DevicesRetention) StopTimer() {
+	if dr.ticker != nil {
+		dr.ticker.Stop()
+		dr.ticker = nil
+		dr.stopChan <- true
+	}
+}
@@ -0,0 +1,400 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"sort"
+	"time"
+)
+
+type Resolution string
+
+const (
+	// the raw devices rows, rolled up periods are returned hourly
+	RESOLUTION_RAW  Resolution = "raw"
+	RESOLUTION_HOUR Resolution = "hour"
+	RESOLUTION_DAY  Resolution = "day"
+)
+
+func ParseResolution(value string) (Resolution, error) {
+	switch Resolution(value) {
+	case RESOLUTION_RAW, RESOLUTION_HOUR, RESOLUTION_DAY:
+		return Resolution(value), nil
+	}
+
+	return "", errors.New("Invalid resolution: " + value)
+}
+
+// The people and devices for the period starting at Timestamp. The average is weighted by time, every devices row
+// is valid until the next one.
+type DevicesStat struct {
+	Timestamp  time.Time `json:"timestamp"`
+	MinPeople  int64     `json:"minPeople"`
+	AvgPeople  float64   `json:"avgPeople"`
+	MaxPeople  int64     `json:"maxPeople"`
+	MinDevices int64     `json:"minDevices"`
+	AvgDevices float64   `json:"avgDevices"`
+	MaxDevices int64     `json:"maxDevices"`
+}
+
+type devicesRow struct {
+	ts      time.Time
+	people  int64
+	devices int64
+}
+
+// Returns the stats in [from, to), ordered by time. Rolled up periods are read from the hourly or daily table, the
+// rest is aggregated from the raw rows. Days are in the local time zone.
+func (db *dbManager) GetDevicesStats(from time.Time, to time.Time, resolution Resolution) ([]DevicesStat, error) {
+	rolledUntil, err := db.devicesRolledUntil()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]DevicesStat, 0, 100)
+	if from.Before(rolledUntil) {
+		table := "devices_hourly"
+		if resolution == RESOLUTION_DAY {
+			table = "devices_daily"
+		}
+		rolledTo := to
+		if rolledUntil.Before(rolledTo) {
+			rolledTo = rolledUntil
+		}
+		stats, err := db.readRollup(table, from, rolledTo)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, stats...)
+	}
+
+	if !to.After(rolledUntil) {
+		return result, nil
+	}
+
+	rawFrom := from
+	if rawFrom.Before(rolledUntil) {
+		rawFrom = rolledUntil
+	}
+	carry, err := db.lastDevicesRowBefore(rawFrom)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.readDevicesRows(rawFrom, to)
+	if err != nil {
+		return nil, err
+	}
+
+	if resolution == RESOLUTION_RAW {
+		for _, row := range rows {
+			result = append(result, DevicesStat{Timestamp: row.ts,
+				MinPeople: row.people, AvgPeople: float64(row.people), MaxPeople: row.people,
+				MinDevices: row.devices, AvgDevices: float64(row.devices), MaxDevices: row.devices})
+		}
+		return result, nil
+	}
+
+	// the last row is valid until now, not until the end of the range
+	end := to
+	if now := time.Now(); now.Before(end) {
+		end = now
+	}
+	bucket := HourBucket
+	if resolution == RESOLUTION_DAY {
+		bucket = dayBucket
+	}
+	return append(result, aggregateDevices(carry, rows, rawFrom, end, bucket)...), nil
+}
+
+// Rolls the raw devices rows of all days before the day of before up into the hourly and daily tables and deletes
+// them. The latest rolled up row is kept, it is still valid at the start of the next day. Returns the number of
+// rolled up days.
+func (db *dbManager) RollupDevices(before time.Time) (int, error) {
+	cutoff := dayStart(before)
+	day, err := db.devicesRolledUntil()
+	if err != nil {
+		return 0, err
+	}
+	if day.IsZero() {
+		first, err := db.firstDevicesRow()
+		if err == sql.ErrNoRows {
+			return 0, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		day = dayStart(first.ts)
+	}
+
+	carry, err := db.lastDevicesRowBefore(day)
+	if err != nil {
+		return 0, err
+	}
+
+	days := 0
+	for ; day.Before(cutoff); day = day.AddDate(0, 0, 1) {
+		next := day.AddDate(0, 0, 1)
+		rows, err := db.readDevicesRows(day, next)
+		if err != nil {
+			return days, err
+		}
+
+		hourly := aggregateDevices(carry, rows, day, next, HourBucket)
+		daily := aggregateDevices(carry, rows, day, next, dayBucket)
+		if err := db.insertRollup(hourly, daily); err != nil {
+			return days, err
+		}
+
+		if len(rows) > 0 {
+			carry = &rows[len(rows)-1]
+		}
+		days++
+	}
+
+	if carry != nil {
+		if err := db.deleteDevicesRows(cutoff, carry.ts); err != nil {
+			return days, err
+		}
+	}
+
+	return days, nil
+}
+
+// the start of the first day that is not rolled up yet, zero if nothing is rolled up
+func (db *dbManager) devicesRolledUntil() (time.Time, error) {
+	var ts time.Time
+	err := db.db.QueryRow("SELECT ts FROM devices_daily ORDER BY ts DESC LIMIT 1").Scan(&ts)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return ts.In(time.Local).AddDate(0, 0, 1), nil
+}
+
+func (db *dbManager) firstDevicesRow() (*devicesRow, error) {
+	var row devicesRow
+	err := db.db.QueryRow("SELECT ts, people, devices FROM devices ORDER BY ts, id LIMIT 1").Scan(&row.ts, &row.people, &row.devices)
+	if err != nil {
+		return nil, err
+	}
+
+	return &row, nil
+}
+
+// nil if there is no row before ts
+func (db *dbManager) lastDevicesRowBefore(ts time.Time) (*devicesRow, error) {
+	var row devicesRow
+	err := db.db.QueryRow("SELECT ts, people, devices FROM devices WHERE ts < ? ORDER BY ts DESC, id DESC LIMIT 1", ts.UTC()).
+		Scan(&row.ts, &row.people, &row.devices)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &row, nil
+}
+
+func (db *dbManager) readDevicesRows(from time.Time, to time.Time) ([]devicesRow, error) {
+	rows, err := db.db.Query("SELECT ts, people, devices FROM devices WHERE ts >= ? AND ts < ? ORDER BY ts, id", from.UTC(), to.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]devicesRow, 0, 100)
+	for rows.Next() {
+		var row devicesRow
+		if err := rows.Scan(&row.ts, &row.people, &row.devices); err != nil {
+			return nil, err
+		}
+		result = append(result, row)
+	}
+
+	return result, rows.Err()
+}
+
+func (db *dbManager) readRollup(table string, from time.Time, to time.Time) ([]DevicesStat, error) {
+	rows, err := db.db.Query("SELECT ts, min_people, avg_people, max_people, min_devices, avg_devices, max_devices FROM "+
+		table+" WHERE ts >= ? AND ts < ? ORDER BY ts", from.UTC(), to.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]DevicesStat, 0, 100)
+	for rows.Next() {
+		var stat DevicesStat
+		err := rows.Scan(&stat.Timestamp, &stat.MinPeople, &stat.AvgPeople, &stat.MaxPeople,
+			&stat.MinDevices, &stat.AvgDevices, &stat.MaxDevices)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, stat)
+	}
+
+	return result, rows.Err()
+}
+
+func (db *dbManager) insertRollup(hourly []DevicesStat, daily []DevicesStat) error {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	insert := func(table string, stats []DevicesStat) error {
+		for _, stat := range stats {
+			_, err := tx.Exec("INSERT INTO "+table+" (ts, min_people, avg_people, max_people, min_devices, avg_devices, max_devices) "+
+				"VALUES (?, ?, ?, ?, ?, ?, ?)", stat.Timestamp.UTC(), stat.MinPeople, stat.AvgPeople, stat.MaxPeople,
+				stat.MinDevices, stat.AvgDevices, stat.MaxDevices)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err := insert("devices_hourly", hourly); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := insert("devices_daily", daily); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// deletes the rows before cutoff and their locations, except the row at keep
+func (db *dbManager) deleteDevicesRows(cutoff time.Time, keep time.Time) error {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM devices_location WHERE devices_id IN (SELECT id FROM devices WHERE ts < ? AND ts <> ?)",
+		cutoff.UTC(), keep.UTC())
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM devices WHERE ts < ? AND ts <> ?", cutoff.UTC(), keep.UTC())
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// returns the start and end of the period of ts
+type bucketFunc func(ts time.Time) (time.Time, time.Time)
+
+// the local hour, also for time zones with a half hour offset. The hourly stats are keyed by its start.
+func HourBucket(ts time.Time) (time.Time, time.Time) {
+	_, offset := ts.In(time.Local).Zone()
+	shift := time.Duration(offset) * time.Second
+	start := ts.Add(shift).Truncate(time.Hour).Add(-shift)
+	return start, start.Add(time.Hour)
+}
+
+func dayBucket(ts time.Time) (time.Time, time.Time) {
+	start := dayStart(ts)
+	return start, start.AddDate(0, 0, 1)
+}
+
+// the local midnight of the day of ts
+func dayStart(ts time.Time) time.Time {
+	ts = ts.In(time.Local)
+	return time.Date(ts.Year(), ts.Month(), ts.Day(), 0, 0, 0, 0, time.Local)
+}
+
+type devicesBucket struct {
+	stat                  DevicesStat
+	peopleSum, devicesSum float64
+	duration              time.Duration
+}
+
+// Aggregates the rows in [start, end) per bucket. Every row is valid until the next one, the carry (the last row
+// before start, may be nil) is valid from start to the first row.
+func aggregateDevices(carry *devicesRow, rows []devicesRow, start time.Time, end time.Time, bucket bucketFunc) []DevicesStat {
+	points := make([]devicesRow, 0, len(rows)+1)
+	if carry != nil {
+		points = append(points, devicesRow{ts: start, people: carry.people, devices: carry.devices})
+	}
+	points = append(points, rows...)
+
+	buckets := make(map[int64]*devicesBucket)
+	for i, point := range points {
+		segmentEnd := end
+		if i+1 < len(points) {
+			segmentEnd = points[i+1].ts
+		}
+
+		// a segment can span several buckets, a row without a duration still counts for min and max
+		for segmentStart := point.ts; ; {
+			bucketStart, bucketEnd := bucket(segmentStart)
+			partEnd := segmentEnd
+			if bucketEnd.Before(partEnd) {
+				partEnd = bucketEnd
+			}
+			b, ok := buckets[bucketStart.Unix()]
+			if !ok {
+				b = &devicesBucket{stat: DevicesStat{Timestamp: bucketStart,
+					MinPeople: point.people, MaxPeople: point.people,
+					MinDevices: point.devices, MaxDevices: point.devices}}
+				buckets[bucketStart.Unix()] = b
+			}
+			b.add(point, partEnd.Sub(segmentStart))
+
+			if !partEnd.Before(segmentEnd) || !partEnd.After(segmentStart) {
+				break
+			}
+			segmentStart = partEnd
+		}
+	}
+
+	result := make([]DevicesStat, 0, len(buckets))
+	for _, b := range buckets {
+		if b.duration > 0 {
+			b.stat.AvgPeople = b.peopleSum / b.duration.Seconds()
+			b.stat.AvgDevices = b.devicesSum / b.duration.Seconds()
+		} else {
+			b.stat.AvgPeople = float64(b.stat.MaxPeople)
+			b.stat.AvgDevices = float64(b.stat.MaxDevices)
+		}
+		result = append(result, b.stat)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Timestamp.Before(result[j].Timestamp)
+	})
+
+	return result
+}
+
+func (b *devicesBucket) add(row devicesRow, duration time.Duration) {
+	if duration < 0 {
+		duration = 0
+	}
+	b.peopleSum += float64(row.people) * duration.Seconds()
+	b.devicesSum += float64(row.devices) * duration.Seconds()
+	b.duration += duration
+
+	if row.people < b.stat.MinPeople {
+		b.stat.MinPeople = row.people
+	}
+	if row.people > b.stat.MaxPeople {
+		b.stat.MaxPeople = row.people
+	}
+	if row.devices < b.stat.MinDevices {
+		b.stat.MinDevices = row.devices
+	}
+	if row.devices > b.stat.MaxDevices {
+		b.stat.MaxDevices = row.devices
+	}
+}
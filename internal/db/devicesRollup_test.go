@@ -0,0 +1,93 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_RollupDevices(t *testing.T) {
+	mgr, cleanup := newTestManager(t)
+	defer cleanup()
+
+	now := time.Now()
+	day1 := dayStart(now).AddDate(0, 0, -3)
+	day2 := day1.AddDate(0, 0, 1)
+	day3 := day2.AddDate(0, 0, 1)
+
+	days, err := mgr.RollupDevices(now)
+	require.Nil(t, err)
+	require.Equal(t, 0, days)
+
+	require.Nil(t, mgr.UpdateDevices(DevicesSample{Timestamp: day1, People: 2, Devices: 4,
+		Locations: []LocationCount{{Location: "Hauptraum", People: 2, Devices: 4}}}))
+	require.Nil(t, mgr.UpdateDevices(DevicesSample{Timestamp: day1.Add(12 * time.Hour), People: 4, Devices: 8}))
+	require.Nil(t, mgr.UpdateDevices(DevicesSample{Timestamp: day2.Add(6 * time.Hour), People: 0, Devices: 1}))
+	require.Nil(t, mgr.UpdateDevices(DevicesSample{Timestamp: now.Add(-time.Minute), People: 1, Devices: 2}))
+
+	days, err = mgr.RollupDevices(day3)
+	require.Nil(t, err)
+	require.Equal(t, 2, days)
+
+	// the last rolled up row is kept for the next day
+	var count int
+	require.Nil(t, mgr.db.QueryRow("SELECT count(*) FROM devices").Scan(&count))
+	require.Equal(t, 2, count)
+	require.Nil(t, mgr.db.QueryRow("SELECT count(*) FROM devices_location").Scan(&count))
+	require.Equal(t, 0, count)
+	require.Nil(t, mgr.db.QueryRow("SELECT count(*) FROM devices_hourly").Scan(&count))
+	require.Equal(t, int(day3.Sub(day1)/time.Hour), count)
+
+	// nothing to do
+	days, err = mgr.RollupDevices(day3)
+	require.Nil(t, err)
+	require.Equal(t, 0, days)
+
+	daily, err := mgr.GetDevicesStats(day1, now.Add(time.Hour), RESOLUTION_DAY)
+	require.Nil(t, err)
+	require.Len(t, daily, 4)
+	require.True(t, daily[0].Timestamp.Equal(day1))
+	require.Equal(t, int64(2), daily[0].MinPeople)
+	require.Equal(t, int64(4), daily[0].MaxPeople)
+	require.InDelta(t, 6.0, daily[0].AvgDevices, 0.1)
+	require.True(t, daily[1].Timestamp.Equal(day2))
+	require.Equal(t, int64(0), daily[1].MinPeople)
+	require.InDelta(t, 1.0, daily[1].AvgPeople, 0.1)
+	// the third day is aggregated from the raw rows
+	require.True(t, daily[2].Timestamp.Equal(day3))
+	require.Equal(t, int64(0), daily[2].MaxPeople)
+	require.Equal(t, int64(1), daily[3].MaxPeople)
+
+	hourly, err := mgr.GetDevicesStats(day1, day2, RESOLUTION_HOUR)
+	require.Nil(t, err)
+	require.Len(t, hourly, int(day2.Sub(day1)/time.Hour))
+	require.Equal(t, 2.0, hourly[0].AvgPeople)
+	require.Equal(t, 4.0, hourly[len(hourly)-1].AvgPeople)
+
+	// the rolled up part is hourly, the rest raw
+	raw, err := mgr.GetDevicesStats(day1, now.Add(time.Hour), RESOLUTION_RAW)
+	require.Nil(t, err)
+	require.Len(t, raw, int(day3.Sub(day1)/time.Hour)+1)
+	require.Equal(t, int64(1), raw[len(raw)-1].MaxPeople)
+}
+
+func Test_aggregateDevices(t *testing.T) {
+	start := time.Date(2020, 3, 1, 10, 0, 0, 0, time.Local)
+	rows := []devicesRow{
+		{ts: start.Add(30 * time.Minute), people: 4, devices: 6},
+		{ts: start.Add(90 * time.Minute), people: 2, devices: 2},
+	}
+
+	stats := aggregateDevices(&devicesRow{people: 0, devices: 2}, rows, start, start.Add(2*time.Hour), HourBucket)
+	require.Len(t, stats, 2)
+	require.Equal(t, DevicesStat{Timestamp: start, MinPeople: 0, AvgPeople: 2, MaxPeople: 4,
+		MinDevices: 2, AvgDevices: 4, MaxDevices: 6}, stats[0])
+	require.Equal(t, DevicesStat{Timestamp: start.Add(time.Hour), MinPeople: 2, AvgPeople: 3, MaxPeople: 4,
+		MinDevices: 2, AvgDevices: 4, MaxDevices: 6}, stats[1])
+
+	// without a carry, the first row starts the first bucket
+	stats = aggregateDevices(nil, rows, start, start.Add(2*time.Hour), HourBucket)
+	require.Len(t, stats, 2)
+	require.Equal(t, 4.0, stats[0].AvgPeople)
+}
@@ -0,0 +1,466 @@
+package db
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/ktt-ol/status2/internal/conf"
+	"github.com/ktt-ol/status2/internal/state"
+)
+
+const (
+	TABLE_SPACESTATE = "spacestate"
+	TABLE_DEVICES    = "devices"
+
+	FORMAT_CSV   = "csv"
+	FORMAT_JSONL = "jsonl"
+)
+
+// the time format of old mysql dumps, in UTC
+const legacyTimeFormat = "2006-01-02 15:04:05"
+
+// Selects the rows for the export and import. Zero times and an empty place don't filter, the place only applies
+// to the spacestate table.
+type ExchangeOptions struct {
+	Table  string
+	Format string
+	From   time.Time
+	To     time.Time
+	Place  Place
+	// import only: checks all rows, but doesn't write anything
+	DryRun bool
+}
+
+type OpenStateRecord struct {
+	Place     Place     `json:"place"`
+	State     string    `json:"state"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+type DevicesRecord struct {
+	Timestamp      time.Time `json:"timestamp"`
+	Devices        int64     `json:"devices"`
+	People         int64     `json:"people"`
+	UnknownDevices int64     `json:"unknownDevices"`
+}
+
+type InvalidRow struct {
+	// the line in the file, starting with 1
+	Line  int
+	Error string
+}
+
+type ImportResult struct {
+	Imported int
+	// rows outside of the time range or of another place
+	Skipped int
+	// rows which are already stored in the database
+	Duplicates int
+	// devices rows of a period which is already rolled up, the rollup would delete them without counting them
+	RolledUp int
+	Invalid  []InvalidRow
+}
+
+var openStateCsvHeader = []string{"place", "state", "timestamp"}
+var devicesCsvHeader = []string{"timestamp", "devices", "people", "unknown_devices"}
+
+func (options ExchangeOptions) validate() error {
+	if options.Table != TABLE_SPACESTATE && options.Table != TABLE_DEVICES {
+		return errors.New("Invalid table: " + options.Table)
+	}
+	if options.Format != FORMAT_CSV && options.Format != FORMAT_JSONL {
+		return errors.New("Invalid format: " + options.Format)
+	}
+	if options.Place != "" && !IsValidPlace(options.Place) {
+		return errors.New("Invalid place: " + string(options.Place))
+	}
+
+	return nil
+}
+
+func (options ExchangeOptions) matches(place Place, ts time.Time) bool {
+	if !options.From.IsZero() && ts.Before(options.From) {
+		return false
+	}
+	if !options.To.IsZero() && !ts.Before(options.To) {
+		return false
+	}
+	return options.Place == "" || options.Table != TABLE_SPACESTATE || options.Place == place
+}
+
+// Writes the rows of the table ordered by time, used by "status2 db export". Returns the number of rows.
+func Export(config conf.DbConf, mysqlConfig conf.MySqlConf, options ExchangeOptions, writer io.Writer) (int, error) {
+	if err := options.validate(); err != nil {
+		return 0, err
+	}
+	db := openManager(config, mysqlConfig)
+	defer db.db.Close()
+	if err := db.checkSchema(); err != nil {
+		return 0, err
+	}
+
+	return db.export(options, writer)
+}
+
+// Reads the rows of the table, the open states are parsed with state.ParseOpenValue. Invalid rows are reported and
+// skipped, the valid rows are written in one transaction. Used by "status2 db import".
+func Import(config conf.DbConf, mysqlConfig conf.MySqlConf, options ExchangeOptions, reader io.Reader) (ImportResult, error) {
+	if err := options.validate(); err != nil {
+		return ImportResult{}, err
+	}
+	db := openManager(config, mysqlConfig)
+	defer db.db.Close()
+	if err := db.checkSchema(); err != nil {
+		return ImportResult{}, err
+	}
+
+	return db.importRows(options, reader)
+}
+
+func (db *dbManager) checkSchema() error {
+	version, err := db.schemaVersion()
+	if err != nil {
+		return err
+	}
+	return compareSchemaVersion(version)
+}
+
+func (db *dbManager) export(options ExchangeOptions, writer io.Writer) (int, error) {
+	var stmt string
+	var args []interface{}
+	var header []string
+	if options.Table == TABLE_SPACESTATE {
+		stmt = "SELECT place, state, timestamp FROM spacestate WHERE 1 = 1"
+		if options.Place != "" {
+			stmt += " AND place = ?"
+			args = append(args, options.Place)
+		}
+		header = openStateCsvHeader
+	} else {
+		stmt = "SELECT ts, devices, people, unknown_devices FROM devices WHERE 1 = 1"
+		header = devicesCsvHeader
+	}
+
+	tsColumn := map[string]string{TABLE_SPACESTATE: "timestamp", TABLE_DEVICES: "ts"}[options.Table]
+	if !options.From.IsZero() {
+		stmt += " AND " + tsColumn + " >= ?"
+		args = append(args, options.From.UTC())
+	}
+	if !options.To.IsZero() {
+		stmt += " AND " + tsColumn + " < ?"
+		args = append(args, options.To.UTC())
+	}
+	stmt += " ORDER BY " + tsColumn + ", id"
+
+	rows, err := db.db.Query(stmt, args...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	out := newRecordWriter(options.Format, writer, header)
+	count := 0
+	for rows.Next() {
+		var record interface{}
+		var fields []string
+		if options.Table == TABLE_SPACESTATE {
+			var r OpenStateRecord
+			if err := rows.Scan(&r.Place, &r.State, &r.Timestamp); err != nil {
+				return count, err
+			}
+			r.Timestamp = r.Timestamp.UTC()
+			record = r
+			fields = []string{string(r.Place), r.State, r.Timestamp.Format(time.RFC3339)}
+		} else {
+			var r DevicesRecord
+			if err := rows.Scan(&r.Timestamp, &r.Devices, &r.People, &r.UnknownDevices); err != nil {
+				return count, err
+			}
+			r.Timestamp = r.Timestamp.UTC()
+			record = r
+			fields = []string{r.Timestamp.Format(time.RFC3339), strconv.FormatInt(r.Devices, 10),
+				strconv.FormatInt(r.People, 10), strconv.FormatInt(r.UnknownDevices, 10)}
+		}
+		if err := out.write(record, fields); err != nil {
+			return count, err
+		}
+		count++
+	}
+	if err := rows.Err(); err != nil {
+		return count, err
+	}
+
+	return count, out.flush()
+}
+
+type recordWriter struct {
+	format    string
+	csvWriter *csv.Writer
+	encoder   *json.Encoder
+}
+
+func newRecordWriter(format string, writer io.Writer, header []string) *recordWriter {
+	if format == FORMAT_JSONL {
+		return &recordWriter{format: format, encoder: json.NewEncoder(writer)}
+	}
+
+	w := &recordWriter{format: format, csvWriter: csv.NewWriter(writer)}
+	w.csvWriter.Write(header)
+	return w
+}
+
+func (w *recordWriter) write(record interface{}, fields []string) error {
+	if w.format == FORMAT_JSONL {
+		return w.encoder.Encode(record)
+	}
+	return w.csvWriter.Write(fields)
+}
+
+func (w *recordWriter) flush() error {
+	if w.csvWriter == nil {
+		return nil
+	}
+	w.csvWriter.Flush()
+	return w.csvWriter.Error()
+}
+
+func (db *dbManager) importRows(options ExchangeOptions, reader io.Reader) (ImportResult, error) {
+	result := ImportResult{Invalid: make([]InvalidRow, 0)}
+	var openStates []OpenStateRecord
+	var devices []DevicesRecord
+
+	var rolledUntil time.Time
+	if options.Table == TABLE_DEVICES {
+		var err error
+		if rolledUntil, err = db.devicesRolledUntil(); err != nil {
+			return result, err
+		}
+	}
+
+	err := readRecords(options.Format, reader, func(line int, jsonLine []byte, fields map[string]string, err error) {
+		var place Place
+		var ts time.Time
+		var openState OpenStateRecord
+		var device DevicesRecord
+		if err == nil {
+			if options.Table == TABLE_SPACESTATE {
+				openState, err = parseOpenStateRecord(jsonLine, fields)
+				place, ts = openState.Place, openState.Timestamp
+			} else {
+				device, err = parseDevicesRecord(jsonLine, fields)
+				ts = device.Timestamp
+			}
+		}
+
+		switch {
+		case err != nil:
+			result.Invalid = append(result.Invalid, InvalidRow{Line: line, Error: err.Error()})
+		case !options.matches(place, ts):
+			result.Skipped++
+		case ts.Before(rolledUntil):
+			result.RolledUp++
+		case options.Table == TABLE_SPACESTATE:
+			openStates = append(openStates, openState)
+		default:
+			devices = append(devices, device)
+		}
+	})
+	if err != nil {
+		return result, err
+	}
+
+	if len(openStates)+len(devices) == 0 {
+		return result, nil
+	}
+
+	// a dry run inserts the rows as well and rolls back, thus the duplicates are counted the same way
+	imported, duplicates, err := db.insertRecords(openStates, devices, options.DryRun)
+	if err != nil {
+		return result, err
+	}
+	result.Imported, result.Duplicates = imported, duplicates
+	return result, nil
+}
+
+// Calls the handler for every row, either with the json line or with the csv fields by header name. Rows the csv
+// reader can't read are passed with the error.
+func readRecords(format string, reader io.Reader, handler func(line int, jsonLine []byte, fields map[string]string, err error)) error {
+	if format == FORMAT_JSONL {
+		scanner := bufio.NewScanner(reader)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		line := 0
+		for scanner.Scan() {
+			line++
+			if len(scanner.Bytes()) == 0 {
+				continue
+			}
+			handler(line, scanner.Bytes(), nil, nil)
+		}
+		return scanner.Err()
+	}
+
+	csvReader := csv.NewReader(reader)
+	csvReader.FieldsPerRecord = -1
+	header, err := csvReader.Read()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// the header is the first line, quoted line breaks are not counted
+	line := 1
+	for {
+		values, err := csvReader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		line++
+		if err != nil {
+			if _, ok := err.(*csv.ParseError); ok {
+				handler(line, nil, nil, err)
+				continue
+			}
+			return err
+		}
+		fields := make(map[string]string, len(header))
+		for i, name := range header {
+			if i < len(values) {
+				fields[name] = values[i]
+			}
+		}
+		handler(line, nil, fields, nil)
+	}
+}
+
+func parseOpenStateRecord(jsonLine []byte, fields map[string]string) (OpenStateRecord, error) {
+	var record OpenStateRecord
+	if jsonLine != nil {
+		if err := json.Unmarshal(jsonLine, &record); err != nil {
+			return record, err
+		}
+	} else {
+		ts, err := parseRecordTime(fields["timestamp"])
+		if err != nil {
+			return record, err
+		}
+		record = OpenStateRecord{Place: Place(fields["place"]), State: fields["state"], Timestamp: ts}
+	}
+
+	if !IsValidPlace(record.Place) {
+		return record, errors.New("Invalid place: " + string(record.Place))
+	}
+	openValue, err := state.ParseOpenValue(record.State)
+	if err != nil {
+		return record, err
+	}
+	record.State = string(openValue)
+	if record.Timestamp.IsZero() {
+		return record, errors.New("Missing timestamp.")
+	}
+
+	return record, nil
+}
+
+func parseDevicesRecord(jsonLine []byte, fields map[string]string) (DevicesRecord, error) {
+	var record DevicesRecord
+	if jsonLine != nil {
+		if err := json.Unmarshal(jsonLine, &record); err != nil {
+			return record, err
+		}
+	} else {
+		ts, err := parseRecordTime(fields["timestamp"])
+		if err != nil {
+			return record, err
+		}
+		record.Timestamp = ts
+		counts := []*int64{&record.Devices, &record.People, &record.UnknownDevices}
+		for i, name := range devicesCsvHeader[1:] {
+			if fields[name] == "" {
+				continue
+			}
+			if *counts[i], err = strconv.ParseInt(fields[name], 10, 64); err != nil {
+				return record, fmt.Errorf("Invalid %s: %s", name, fields[name])
+			}
+		}
+	}
+
+	if record.Timestamp.IsZero() {
+		return record, errors.New("Missing timestamp.")
+	}
+	if record.Devices < 0 || record.People < 0 || record.UnknownDevices < 0 {
+		return record, errors.New("Negative count.")
+	}
+
+	return record, nil
+}
+
+// RFC3339 or the legacy mysql format in UTC
+func parseRecordTime(value string) (time.Time, error) {
+	if ts, err := time.Parse(time.RFC3339, value); err == nil {
+		return ts, nil
+	}
+	if ts, err := time.Parse(legacyTimeFormat, value); err == nil {
+		return ts, nil
+	}
+
+	return time.Time{}, errors.New("Invalid timestamp: " + value)
+}
+
+func (db *dbManager) insertRecords(openStates []OpenStateRecord, devices []DevicesRecord, rollback bool) (int, int, error) {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	imported, duplicates := 0, 0
+	// the transaction sees its own inserts, thus duplicates within the file are skipped as well
+	insert := func(existsQuery string, existsArgs []interface{}, insertQuery string, insertArgs ...interface{}) error {
+		var count int
+		if err := tx.QueryRow(existsQuery, existsArgs...).Scan(&count); err != nil {
+			return err
+		}
+		if count > 0 {
+			duplicates++
+			return nil
+		}
+		if _, err := tx.Exec(insertQuery, insertArgs...); err != nil {
+			return err
+		}
+		imported++
+		return nil
+	}
+
+	for _, record := range openStates {
+		ts := record.Timestamp.UTC()
+		err := insert("SELECT COUNT(*) FROM spacestate WHERE place = ? AND timestamp = ? AND state = ?",
+			[]interface{}{record.Place, ts, record.State},
+			"INSERT INTO spacestate (state, place, timestamp) VALUES (?, ?, ?)", record.State, record.Place, ts)
+		if err != nil {
+			tx.Rollback()
+			return 0, 0, err
+		}
+	}
+	for _, record := range devices {
+		ts := record.Timestamp.UTC()
+		err := insert("SELECT COUNT(*) FROM devices WHERE ts = ?", []interface{}{ts},
+			"INSERT INTO devices (devices, people, unknown_devices, ts) VALUES (?, ?, ?, ?)",
+			record.Devices, record.People, record.UnknownDevices, ts)
+		if err != nil {
+			tx.Rollback()
+			return 0, 0, err
+		}
+	}
+
+	if rollback {
+		return imported, duplicates, tx.Rollback()
+	}
+	return imported, duplicates, tx.Commit()
+}
@@ -0,0 +1,139 @@
+package db
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ktt-ol/status2/internal/state"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ExportImport(t *testing.T) {
+	source, cleanupSource := newTestManager(t)
+	defer cleanupSource()
+	target, cleanupTarget := newTestManager(t)
+	defer cleanupTarget()
+
+	require.Nil(t, source.UpdateOpenState(PLACE_SPACE, state.OpenValueTs{Value: state.OPEN, Timestamp: 1000}))
+	require.Nil(t, source.UpdateOpenState(PLACE_LAB3D, state.OpenValueTs{Value: state.OPEN, Timestamp: 1100}))
+	require.Nil(t, source.UpdateOpenState(PLACE_SPACE, state.OpenValueTs{Value: state.NONE, Timestamp: 2000}))
+	require.Nil(t, source.UpdateDevices(DevicesSample{Timestamp: time.Unix(1000, 0), Devices: 4, People: 2, UnknownDevices: 1}))
+
+	// the second import of the same rows only finds duplicates
+	for i, format := range []string{FORMAT_CSV, FORMAT_JSONL} {
+		var buf bytes.Buffer
+		options := ExchangeOptions{Table: TABLE_SPACESTATE, Format: format, Place: PLACE_SPACE}
+		count, err := source.export(options, &buf)
+		require.Nil(t, err)
+		require.Equal(t, 2, count)
+
+		result, err := target.importRows(options, bytes.NewReader(buf.Bytes()))
+		require.Nil(t, err)
+		require.Equal(t, 2-2*i, result.Imported)
+		require.Equal(t, 2*i, result.Duplicates)
+		require.Len(t, result.Invalid, 0)
+	}
+
+	states, err := target.GetOpenStates(PLACE_SPACE, time.Unix(0, 0), time.Unix(3000, 0))
+	require.Nil(t, err)
+	require.Len(t, states, 2)
+	require.Equal(t, state.NONE, states[1].Value)
+	require.Equal(t, int64(2000), states[1].Time.Unix())
+
+	var buf bytes.Buffer
+	options := ExchangeOptions{Table: TABLE_DEVICES, Format: FORMAT_CSV, From: time.Unix(500, 0), To: time.Unix(1500, 0)}
+	count, err := source.export(options, &buf)
+	require.Nil(t, err)
+	require.Equal(t, 1, count)
+	require.Equal(t, "timestamp,devices,people,unknown_devices\n1970-01-01T00:16:40Z,4,2,1\n", buf.String())
+
+	result, err := target.importRows(options, bytes.NewReader(buf.Bytes()))
+	require.Nil(t, err)
+	require.Equal(t, 1, result.Imported)
+	result, err = target.importRows(options, bytes.NewReader(buf.Bytes()))
+	require.Nil(t, err)
+	require.Equal(t, 0, result.Imported)
+	require.Equal(t, 1, result.Duplicates)
+	last, err := target.GetLastDevicesData()
+	require.Nil(t, err)
+	require.Equal(t, LastDevices{Devices: 4, People: 2, UnknownDevices: 1, Timestamp: time.Unix(1000, 0).UTC()}, *last)
+}
+
+func Test_ImportDevicesAfterRollup(t *testing.T) {
+	mgr, cleanup := newTestManager(t)
+	defer cleanup()
+
+	day1 := dayStart(time.Now()).AddDate(0, 0, -3)
+	day2 := day1.AddDate(0, 0, 1)
+	require.Nil(t, mgr.UpdateDevices(DevicesSample{Timestamp: day1.Add(time.Hour), People: 2, Devices: 4}))
+	days, err := mgr.RollupDevices(day2)
+	require.Nil(t, err)
+	require.Equal(t, 1, days)
+
+	input := fmt.Sprintf("timestamp,devices,people,unknown_devices\n%s,1,1,0\n%s,3,2,0\n",
+		day1.Add(2*time.Hour).UTC().Format(time.RFC3339), day2.Add(2*time.Hour).UTC().Format(time.RFC3339))
+	result, err := mgr.importRows(ExchangeOptions{Table: TABLE_DEVICES, Format: FORMAT_CSV}, strings.NewReader(input))
+	require.Nil(t, err)
+	require.Equal(t, 1, result.Imported)
+	require.Equal(t, 1, result.RolledUp)
+
+	var count int
+	require.Nil(t, mgr.db.QueryRow("SELECT count(*) FROM devices WHERE ts < ?", day2.UTC()).Scan(&count))
+	require.Equal(t, 1, count)
+}
+
+func Test_ImportDryRun(t *testing.T) {
+	mgr, cleanup := newTestManager(t)
+	defer cleanup()
+
+	input := `place,state,timestamp
+space,on,2015-01-01 10:00:00
+space,closed,2015-01-01T18:00:00Z
+mars,open,2015-01-02T10:00:00Z
+space,wat,2015-01-02T10:00:00Z
+space,open,yesterday
+lab3d,open,2015-01-03T10:00:00Z
+space,open,2016-01-01T10:00:00Z
+`
+	options := ExchangeOptions{Table: TABLE_SPACESTATE, Format: FORMAT_CSV, Place: PLACE_SPACE,
+		To: time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC), DryRun: true}
+	result, err := mgr.importRows(options, strings.NewReader(input))
+	require.Nil(t, err)
+	require.Equal(t, 2, result.Imported)
+	require.Equal(t, 2, result.Skipped)
+	require.Len(t, result.Invalid, 3)
+	require.Equal(t, 4, result.Invalid[0].Line)
+	require.Equal(t, 5, result.Invalid[1].Line)
+	require.Equal(t, 6, result.Invalid[2].Line)
+
+	// nothing written
+	states, err := mgr.GetOpenStates(PLACE_SPACE, time.Unix(0, 0), time.Now())
+	require.Nil(t, err)
+	require.Len(t, states, 0)
+
+	// the legacy values are stored as the current ones
+	options.DryRun = false
+	result, err = mgr.importRows(options, strings.NewReader(input))
+	require.Nil(t, err)
+	require.Equal(t, 2, result.Imported)
+	states, err = mgr.GetOpenStates(PLACE_SPACE, time.Unix(0, 0), time.Now())
+	require.Nil(t, err)
+	require.Equal(t, []OpenState{
+		{Value: state.OPEN, Time: time.Date(2015, 1, 1, 10, 0, 0, 0, time.UTC)},
+		{Value: state.NONE, Time: time.Date(2015, 1, 1, 18, 0, 0, 0, time.UTC)},
+	}, states)
+
+	// a dry run reports the stored rows as duplicates
+	options.DryRun = true
+	result, err = mgr.importRows(options, strings.NewReader(input))
+	require.Nil(t, err)
+	require.Equal(t, 0, result.Imported)
+	require.Equal(t, 2, result.Duplicates)
+
+	_, err = mgr.importRows(ExchangeOptions{Table: TABLE_DEVICES, Format: FORMAT_JSONL}, strings.NewReader(`{"timestamp": "x"`))
+	require.Nil(t, err)
+	require.NotNil(t, ExchangeOptions{Table: "power", Format: FORMAT_CSV}.validate())
+}
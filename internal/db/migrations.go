@@ -0,0 +1,352 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// A schema change. The statements are executed in order, the version is stored in the schema_version table.
+type migration struct {
+	version     int
+	description string
+	mysql       []string
+	sqlite      []string
+}
+
+// All migrations, ordered by version. Never change an existing migration, always add a new one.
+var migrations = []migration{
+	{
+		version:     1,
+		description: "initial schema",
+		mysql: []string{
+			"CREATE TABLE IF NOT EXISTS `devices` (\n" +
+				"  `id` bigint(20) NOT NULL AUTO_INCREMENT,\n" +
+				"  `devices` int(11) NOT NULL DEFAULT '0',\n" +
+				"  `people` int(11) NOT NULL DEFAULT '0',\n" +
+				"  `ts` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,\n" +
+				"  PRIMARY KEY (`id`)\n" +
+				") ENGINE=MyISAM  DEFAULT CHARSET=latin1",
+			"CREATE TABLE IF NOT EXISTS `spacestate` (\n" +
+				"  `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT,\n" +
+				"  `state` varchar(50) NOT NULL,\n" +
+				"  `until` datetime DEFAULT NULL,\n" +
+				"  `lastupdate` datetime DEFAULT NULL,\n" +
+				"  `timestamp` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,\n" +
+				"  `place` varchar(20) NOT NULL DEFAULT 'space',\n" +
+				"  PRIMARY KEY (`id`)\n" +
+				") ENGINE=MyISAM  DEFAULT CHARSET=latin1",
+		},
+		sqlite: []string{
+			`CREATE TABLE IF NOT EXISTS devices (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  devices INTEGER NOT NULL DEFAULT 0,
+  people INTEGER NOT NULL DEFAULT 0,
+  ts TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+)`,
+			`CREATE TABLE IF NOT EXISTS spacestate (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  state VARCHAR(50) NOT NULL,
+  until DATETIME DEFAULT NULL,
+  lastupdate DATETIME DEFAULT NULL,
+  timestamp TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  place VARCHAR(20) NOT NULL DEFAULT 'space'
+)`,
+		},
+	},
+	{
+		version:     2,
+		description: "InnoDB and utf8mb4",
+		mysql: []string{
+			"ALTER TABLE `devices` ENGINE=InnoDB",
+			"ALTER TABLE `devices` CONVERT TO CHARACTER SET utf8mb4 COLLATE utf8mb4_unicode_ci",
+			"ALTER TABLE `spacestate` ENGINE=InnoDB",
+			"ALTER TABLE `spacestate` CONVERT TO CHARACTER SET utf8mb4 COLLATE utf8mb4_unicode_ci",
+		},
+		// nothing to do for sqlite
+		sqlite: []string{},
+	},
+	{
+		version:     3,
+		description: "indexes for the last state per place and the devices time",
+		mysql: []string{
+			"CREATE INDEX `idx_spacestate_place_id` ON `spacestate` (`place`, `id`)",
+			"CREATE INDEX `idx_devices_ts` ON `devices` (`ts`)",
+		},
+		sqlite: []string{
+			"CREATE INDEX idx_spacestate_place_id ON spacestate (place, id)",
+			"CREATE INDEX idx_devices_ts ON devices (ts)",
+		},
+	},
+	{
+		version:     4,
+		description: "power time series",
+		mysql: []string{
+			"CREATE TABLE `power` (\n" +
+				"  `id` bigint(20) NOT NULL AUTO_INCREMENT,\n" +
+				"  `meter` varchar(20) NOT NULL,\n" +
+				"  `ts` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,\n" +
+				"  `avg` double NOT NULL,\n" +
+				"  `max` double NOT NULL,\n" +
+				"  `samples` int(11) NOT NULL,\n" +
+				"  PRIMARY KEY (`id`),\n" +
+				"  KEY `idx_power_meter_ts` (`meter`, `ts`)\n" +
+				") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4",
+		},
+		sqlite: []string{
+			`CREATE TABLE power (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  meter VARCHAR(20) NOT NULL,
+  ts TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  avg DOUBLE NOT NULL,
+  max DOUBLE NOT NULL,
+  samples INTEGER NOT NULL
+)`,
+			"CREATE INDEX idx_power_meter_ts ON power (meter, ts)",
+		},
+	},
+	{
+		version:     5,
+		description: "duration of the power samples",
+		mysql: []string{
+			"ALTER TABLE `power` ADD COLUMN `duration` int(11) NOT NULL DEFAULT 60",
+		},
+		sqlite: []string{
+			"ALTER TABLE power ADD COLUMN duration INTEGER NOT NULL DEFAULT 60",
+		},
+	},
+	{
+		version:     6,
+		description: "unknown devices and devices per location",
+		mysql: []string{
+			"ALTER TABLE `devices` ADD COLUMN `unknown_devices` int(11) NOT NULL DEFAULT '0'",
+			"CREATE TABLE `devices_location` (\n" +
+				"  `id` bigint(20) NOT NULL AUTO_INCREMENT,\n" +
+				"  `devices_id` bigint(20) NOT NULL,\n" +
+				"  `location` varchar(100) NOT NULL,\n" +
+				"  `devices` int(11) NOT NULL DEFAULT '0',\n" +
+				"  `people` int(11) NOT NULL DEFAULT '0',\n" +
+				"  PRIMARY KEY (`id`),\n" +
+				"  KEY `idx_devices_location_devices_id` (`devices_id`)\n" +
+				") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4",
+		},
+		sqlite: []string{
+			"ALTER TABLE devices ADD COLUMN unknown_devices INTEGER NOT NULL DEFAULT 0",
+			`CREATE TABLE devices_location (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  devices_id INTEGER NOT NULL,
+  location VARCHAR(100) NOT NULL,
+  devices INTEGER NOT NULL DEFAULT 0,
+  people INTEGER NOT NULL DEFAULT 0
+)`,
+			"CREATE INDEX idx_devices_location_devices_id ON devices_location (devices_id)",
+		},
+	},
+	{
+		version:     7,
+		description: "index for the open state ranges",
+		mysql: []string{
+			"CREATE INDEX `idx_spacestate_place_timestamp` ON `spacestate` (`place`, `timestamp`)",
+		},
+		sqlite: []string{
+			"CREATE INDEX idx_spacestate_place_timestamp ON spacestate (place, timestamp)",
+		},
+	},
+	{
+		version:     8,
+		description: "hourly and daily rollup of the devices",
+		mysql: []string{
+			"CREATE TABLE `devices_hourly` (\n" +
+				"  `id` bigint(20) NOT NULL AUTO_INCREMENT,\n" +
+				"  `ts` datetime NOT NULL,\n" +
+				"  `min_people` int(11) NOT NULL,\n" +
+				"  `avg_people` double NOT NULL,\n" +
+				"  `max_people` int(11) NOT NULL,\n" +
+				"  `min_devices` int(11) NOT NULL,\n" +
+				"  `avg_devices` double NOT NULL,\n" +
+				"  `max_devices` int(11) NOT NULL,\n" +
+				"  PRIMARY KEY (`id`),\n" +
+				"  UNIQUE KEY `idx_devices_hourly_ts` (`ts`)\n" +
+				") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4",
+			"CREATE TABLE `devices_daily` (\n" +
+				"  `id` bigint(20) NOT NULL AUTO_INCREMENT,\n" +
+				"  `ts` datetime NOT NULL,\n" +
+				"  `min_people` int(11) NOT NULL,\n" +
+				"  `avg_people` double NOT NULL,\n" +
+				"  `max_people` int(11) NOT NULL,\n" +
+				"  `min_devices` int(11) NOT NULL,\n" +
+				"  `avg_devices` double NOT NULL,\n" +
+				"  `max_devices` int(11) NOT NULL,\n" +
+				"  PRIMARY KEY (`id`),\n" +
+				"  UNIQUE KEY `idx_devices_daily_ts` (`ts`)\n" +
+				") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4",
+		},
+		sqlite: []string{
+			`CREATE TABLE devices_hourly (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  ts DATETIME NOT NULL,
+  min_people INTEGER NOT NULL,
+  avg_people REAL NOT NULL,
+  max_people INTEGER NOT NULL,
+  min_devices INTEGER NOT NULL,
+  avg_devices REAL NOT NULL,
+  max_devices INTEGER NOT NULL
+)`,
+			"CREATE UNIQUE INDEX idx_devices_hourly_ts ON devices_hourly (ts)",
+			`CREATE TABLE devices_daily (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  ts DATETIME NOT NULL,
+  min_people INTEGER NOT NULL,
+  avg_people REAL NOT NULL,
+  max_people INTEGER NOT NULL,
+  min_devices INTEGER NOT NULL,
+  avg_devices REAL NOT NULL,
+  max_devices INTEGER NOT NULL
+)`,
+			"CREATE UNIQUE INDEX idx_devices_daily_ts ON devices_daily (ts)",
+		},
+	},
+	{
+		version:     9,
+		description: "devices timestamps in UTC",
+		// the old rows were written with NOW() in the session time zone, the new ones are written as UTC like the
+		// spacestate timestamps. UNIX_TIMESTAMP of a timestamp column is the real point in time, thus this works
+		// for every server time zone without the time zone tables.
+		mysql: []string{
+			"UPDATE `devices` SET `ts` = DATE_ADD('1970-01-01 00:00:00', INTERVAL UNIX_TIMESTAMP(`ts`) SECOND)",
+		},
+		// sqlite databases were always written in UTC
+		sqlite: []string{},
+	},
+}
+
+const mysqlSchemaVersionTable = "CREATE TABLE IF NOT EXISTS `schema_version` (\n" +
+	"  `version` int(11) NOT NULL,\n" +
+	"  `description` varchar(255) NOT NULL,\n" +
+	"  `applied` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,\n" +
+	"  PRIMARY KEY (`version`)\n" +
+	") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4"
+
+const sqliteSchemaVersionTable = `CREATE TABLE IF NOT EXISTS schema_version (
+  version INTEGER PRIMARY KEY,
+  description VARCHAR(255) NOT NULL,
+  applied TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+)`
+
+// the schema version the code expects
+func latestSchemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+func (m migration) statements(driver string) []string {
+	if driver == DRIVER_SQLITE {
+		return m.sqlite
+	}
+	return m.mysql
+}
+
+// Returns the current schema version, 0 for an empty db. Creates the schema_version table if necessary.
+func (db *dbManager) schemaVersion() (int, error) {
+	createStmt := mysqlSchemaVersionTable
+	if db.driver == DRIVER_SQLITE {
+		createStmt = sqliteSchemaVersionTable
+	}
+	if _, err := db.db.Exec(createStmt); err != nil {
+		return 0, err
+	}
+
+	var version int
+	err := db.db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_version").Scan(&version)
+	return version, err
+}
+
+func (db *dbManager) migrate() error {
+	current, err := db.schemaVersion()
+	if err != nil {
+		return err
+	}
+	if current > latestSchemaVersion() {
+		return fmt.Errorf("the db schema version %d is newer than the supported version %d", current, latestSchemaVersion())
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+
+		logger.WithField("version", m.version).WithField("description", m.description).Info("Applying db migration.")
+		if err := db.applyMigration(m); err != nil {
+			return err
+		}
+	}
+
+	if current < latestSchemaVersion() {
+		logger.WithField("version", latestSchemaVersion()).Info("Db schema is up to date.")
+	}
+	return nil
+}
+
+// Executes the statements and stores the version in one transaction, thus a failed migration can be applied again.
+// Sqlite rolls back the schema changes as well. Mysql commits every schema change implicitly, thus the changes of
+// a failed attempt are skipped the next time.
+func (db *dbManager) applyMigration(m migration) error {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	for _, stmt := range m.statements(db.driver) {
+		if _, err := tx.Exec(stmt); err != nil {
+			if isAlreadyAppliedError(err) {
+				logger.WithField("version", m.version).WithError(err).Info("Schema change already applied, skipping it.")
+				continue
+			}
+			tx.Rollback()
+			return fmt.Errorf("migration %d failed: %s", m.version, err)
+		}
+	}
+	_, err = tx.Exec("INSERT INTO schema_version (version, description, applied) VALUES (?, ?, ?)",
+		m.version, m.description, time.Now())
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// the mysql errors of a schema change which was done by an earlier, failed attempt
+func isAlreadyAppliedError(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		switch mysqlErr.Number {
+		// table exists, duplicate column, duplicate key name
+		case 1050, 1060, 1061:
+			return true
+		}
+	}
+	return false
+}
+
+// Exits if the db schema doesn't match the code.
+func (db *dbManager) checkSchemaVersion() {
+	current, err := db.schemaVersion()
+	if err != nil {
+		logger.WithError(err).Fatal("Can't read the db schema version.")
+	}
+
+	if err := compareSchemaVersion(current); err != nil {
+		logger.WithError(err).Fatal("Invalid db schema.")
+	}
+}
+
+func compareSchemaVersion(current int) error {
+	switch {
+	case current < latestSchemaVersion():
+		return fmt.Errorf("the db schema version %d is outdated (expected %d), run 'status2 db migrate' or enable autoMigrate", current, latestSchemaVersion())
+	case current > latestSchemaVersion():
+		return errors.New("the db schema is newer than this status2 version")
+	}
+	return nil
+}
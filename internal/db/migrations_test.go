@@ -0,0 +1,81 @@
+package db
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_migrationsOrder(t *testing.T) {
+	for i, m := range migrations {
+		require.Equal(t, i+1, m.version)
+		require.NotEmpty(t, m.description)
+		require.NotEmpty(t, m.mysql)
+		require.NotNil(t, m.sqlite)
+	}
+}
+
+func Test_migrate(t *testing.T) {
+	mgr, cleanup := newTestManager(t)
+	defer cleanup()
+
+	version, err := mgr.schemaVersion()
+	require.Nil(t, err)
+	require.Equal(t, latestSchemaVersion(), version)
+
+	// nothing to do the second time
+	require.Nil(t, mgr.migrate())
+	var count int
+	require.Nil(t, mgr.db.QueryRow("SELECT count(*) FROM schema_version").Scan(&count))
+	require.Equal(t, len(migrations), count)
+
+	require.Nil(t, mgr.db.QueryRow("SELECT count(*) FROM sqlite_master WHERE type = 'index' AND name LIKE 'idx_%'").Scan(&count))
+	require.Equal(t, 7, count)
+
+	// newer schema than the code
+	_, err = mgr.db.Exec("INSERT INTO schema_version (version, description) VALUES (?, 'future')", latestSchemaVersion()+1)
+	require.Nil(t, err)
+	require.NotNil(t, mgr.migrate())
+}
+
+func Test_migrate_partialFailure(t *testing.T) {
+	mgr, cleanup := newTestManager(t)
+	defer cleanup()
+
+	original := migrations
+	defer func() { migrations = original }()
+	failing := migration{version: latestSchemaVersion() + 1, description: "failing", mysql: []string{"-"},
+		sqlite: []string{"CREATE TABLE test_partial (id INTEGER)", "CREATE INDEX idx_test_partial ON missing (id)"}}
+	migrations = append(append([]migration{}, original...), failing)
+
+	require.NotNil(t, mgr.migrate())
+	version, err := mgr.schemaVersion()
+	require.Nil(t, err)
+	require.Equal(t, len(original), version)
+	// the first statement is rolled back
+	var count int
+	require.Nil(t, mgr.db.QueryRow("SELECT count(*) FROM sqlite_master WHERE name = 'test_partial'").Scan(&count))
+	require.Equal(t, 0, count)
+
+	// the fixed migration runs again from the start
+	failing.sqlite[1] = "CREATE INDEX idx_test_partial ON test_partial (id)"
+	require.Nil(t, mgr.migrate())
+	version, err = mgr.schemaVersion()
+	require.Nil(t, err)
+	require.Equal(t, latestSchemaVersion(), version)
+}
+
+func Test_isAlreadyAppliedError(t *testing.T) {
+	require.True(t, isAlreadyAppliedError(&mysql.MySQLError{Number: 1061, Message: "Duplicate key name"}))
+	require.True(t, isAlreadyAppliedError(&mysql.MySQLError{Number: 1060, Message: "Duplicate column name"}))
+	require.False(t, isAlreadyAppliedError(&mysql.MySQLError{Number: 1146, Message: "Table doesn't exist"}))
+	require.False(t, isAlreadyAppliedError(errors.New("near \"(\": syntax error")))
+}
+
+func Test_compareSchemaVersion(t *testing.T) {
+	require.Nil(t, compareSchemaVersion(latestSchemaVersion()))
+	require.NotNil(t, compareSchemaVersion(0))
+	require.NotNil(t, compareSchemaVersion(latestSchemaVersion()+1))
+}
@@ -14,8 +14,13 @@ type OpenStatePersistence struct {
 func NewOpenStatePersistence(dbManager DbManager, ev events.EventManager, st *state.State) {
 	ops := OpenStatePersistence{dbManager, st, make(map[Place]state.OpenValueTs)}
 
-	for _, openState := range dbManager.GetLastOpenStates() {
+	lastOpenStates, err := dbManager.GetLastOpenStates()
+	if err != nil {
+		logger.WithError(err).Warn("Can't read the last open states, nothing to restore.")
+	}
+	for _, openState := range lastOpenStates {
 		ops.lastOpenStates[openState.Place] = openState.State
+		ops.restoreState(openState)
 	}
 
 	ev.On(events.TOPIC_SPACE_OPEN_STATE, ops.onChange)
@@ -24,6 +29,21 @@ func NewOpenStatePersistence(dbManager DbManager, ev events.EventManager, st *st
 	ev.On(events.TOPIC_MACHINING_OPEN_STATE, ops.onChange)
 }
 
+// sets the last known state from the db, until the (retained) mqtt values arrive
+func (ops *OpenStatePersistence) restoreState(openState LastOpenStates) {
+	currentState, err := ops.st.Open.ForPlace(openState.Place)
+	if err != nil {
+		logger.WithError(err).Warn("Can't restore the open state.")
+		return
+	}
+	if currentState.Timestamp != 0 {
+		return
+	}
+
+	*currentState = openState.State
+	currentState.Restored = true
+}
+
 func (ops *OpenStatePersistence) onChange(topic events.EventName) {
 	currentState, _ := ops.st.Open.OpenStateForEvent(topic)
 
@@ -36,6 +56,8 @@ func (ops *OpenStatePersistence) onChange(topic events.EventName) {
 	}
 
 	logger.WithField("topic", topic).Info("Update topic in db")
-	ops.dbManager.UpdateOpenState(place, *currentState)
+	if err := ops.dbManager.UpdateOpenState(place, *currentState); err != nil {
+		logger.WithError(err).Error("Can't update the open state.")
+	}
 	ops.lastOpenStates[place] = *currentState
 }
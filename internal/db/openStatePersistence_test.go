@@ -10,14 +10,21 @@ import (
 func Test_OpenStatePersistence(t *testing.T) {
 	dbMock := new(DbManagerMock)
 	dbMock.LastOpenStatesValues = []LastOpenStates{
-		{PLACE_SPACE, state.OpenValueTs{state.OPEN, 1234}},
-		{PLACE_MACHINING, state.OpenValueTs{state.NONE, 1234}},
+		{PLACE_SPACE, state.OpenValueTs{Value: state.OPEN, Timestamp: 1234}},
+		{PLACE_MACHINING, state.OpenValueTs{Value: state.NONE, Timestamp: 1234}},
 	}
 	ev := events.NewEventManager()
 	appState := state.NewDefaultState()
 
 	NewOpenStatePersistence(dbMock, ev, appState)
 
+	// the db states are restored
+	require.Equal(t, state.OPEN, appState.Open.Space.Value)
+	require.Equal(t, int64(1234), appState.Open.Space.Timestamp)
+	require.True(t, appState.Open.Space.Restored)
+	require.Equal(t, int64(1234), appState.Open.Machining.Timestamp)
+	require.Equal(t, int64(0), appState.Open.Radstelle.Timestamp)
+
 	// no changes, because the state was the same
 	appState.Open.Space.Value = state.OPEN
 	appState.Open.Space.Timestamp = 1
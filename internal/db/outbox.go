@@ -0,0 +1,401 @@
+package db
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/ktt-ol/status2/internal/metrics"
+	"github.com/ktt-ol/status2/internal/state"
+	"github.com/mattn/go-sqlite3"
+)
+
+const (
+	OUTBOX_OPEN_STATE = "openState"
+	OUTBOX_DEVICES    = "devices"
+	OUTBOX_POWER      = "power"
+
+	outboxMinBackoff = 1 * time.Second
+	outboxMaxBackoff = 2 * time.Minute
+	// a write that fails this often while the db is reachable is rejected
+	outboxMaxAttempts = 5
+	// rejected writes are appended to the outbox file name with this suffix
+	outboxRejectedSuffix = ".rejected"
+)
+
+// A pending write, stored as one json line in the outbox file.
+type outboxEntry struct {
+	Kind      string          `json:"kind"`
+	Place     Place           `json:"place,omitempty"`
+	Value     state.OpenValue `json:"value,omitempty"`
+	Devices   *DevicesSample  `json:"devices,omitempty"`
+	Power     *PowerSample    `json:"power,omitempty"`
+	Timestamp time.Time       `json:"timestamp"`
+	// only set in the file of the rejected writes
+	Error string `json:"error,omitempty"`
+}
+
+// Queues all writes and sends them to the db in order. If the db is not available, the writes are retried with
+// an exponential backoff. The pending writes are stored in a file to survive a restart. A write can be repeated if
+// the process stops between the db write and the file update.
+// A write the db doesn't accept, e.g. a constraint violation, must not block the later ones. It's rejected: logged and
+// moved to the ".rejected" file next to the outbox file.
+// Reads are passed directly to the db.
+type outbox struct {
+	db       DbManager
+	filename string
+
+	lock      sync.Mutex
+	pending   []outboxEntry
+	degraded  bool
+	lastError string
+	// the failed writes of the first pending entry while the db was reachable
+	headAttempts int
+
+	wakeup chan bool
+}
+
+func newOutbox(db DbManager, filename string) *outbox {
+	o := &outbox{db: db, filename: filename, wakeup: make(chan bool, 1)}
+	if filename == "" {
+		logger.Warn("No outbox file is configured, pending db writes are lost on restart.")
+	} else {
+		pending, err := loadOutboxFile(filename)
+		if err != nil {
+			logger.WithError(err).Error("Can't read the outbox file.")
+		}
+		if len(pending) > 0 {
+			logger.WithField("pending", len(pending)).Info("Found pending db writes in the outbox.")
+		}
+		o.pending = pending
+	}
+
+	go o.worker()
+	o.notify()
+	return o
+}
+
+func (o *outbox) GetLastOpenStates() ([]LastOpenStates, error) {
+	result, err := o.db.GetLastOpenStates()
+	o.updateStatus(err)
+	return result, err
+}
+
+func (o *outbox) GetLastDevicesData() (*LastDevices, error) {
+	result, err := o.db.GetLastDevicesData()
+	o.updateStatus(err)
+	return result, err
+}
+
+func (o *outbox) GetOpenStates(place Place, from time.Time, to time.Time) ([]OpenState, error) {
+	result, err := o.db.GetOpenStates(place, from, to)
+	o.updateStatus(err)
+	return result, err
+}
+
+func (o *outbox) ForEachOpenState(place Place, from time.Time, to time.Time, handler func(openState OpenState) error) error {
+	err := o.db.ForEachOpenState(place, from, to, handler)
+	o.updateStatus(err)
+	return err
+}
+
+// Queues the write, never returns an error.
+func (o *outbox) UpdateOpenState(place Place, openValue state.OpenValueTs) error {
+	o.add(outboxEntry{Kind: OUTBOX_OPEN_STATE, Place: place, Value: openValue.Value,
+		Timestamp: time.Unix(openValue.Timestamp, 0)})
+	return nil
+}
+
+// Queues the write, never returns an error.
+func (o *outbox) UpdateDevices(sample DevicesSample) error {
+	o.add(outboxEntry{Kind: OUTBOX_DEVICES, Devices: &sample, Timestamp: sample.Timestamp})
+	return nil
+}
+
+func (o *outbox) GetDevicesStats(from time.Time, to time.Time, resolution Resolution) ([]DevicesStat, error) {
+	result, err := o.db.GetDevicesStats(from, to, resolution)
+	o.updateStatus(err)
+	return result, err
+}
+
+func (o *outbox) RollupDevices(before time.Time) (int, error) {
+	days, err := o.db.RollupDevices(before)
+	o.updateStatus(err)
+	return days, err
+}
+
+func (o *outbox) GetPowerSamples(meter state.PowerMeter, from time.Time, to time.Time) ([]PowerSample, error) {
+	result, err := o.db.GetPowerSamples(meter, from, to)
+	o.updateStatus(err)
+	return result, err
+}
+
+func (o *outbox) ForEachPowerSample(meter state.PowerMeter, from time.Time, to time.Time, handler func(sample PowerSample) error) error {
+	err := o.db.ForEachPowerSample(meter, from, to, handler)
+	o.updateStatus(err)
+	return err
+}
+
+// Queues the write, never returns an error.
+func (o *outbox) UpdatePower(sample PowerSample) error {
+	o.add(outboxEntry{Kind: OUTBOX_POWER, Power: &sample, Timestamp: sample.Timestamp})
+	return nil
+}
+
+func (o *outbox) Status() DbStatus {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	return DbStatus{Degraded: o.degraded, PendingWrites: len(o.pending), LastError: o.lastError}
+}
+
+func (o *outbox) Ping() error {
+	return o.db.Ping()
+}
+
+func (o *outbox) add(entry outboxEntry) {
+	o.lock.Lock()
+	o.pending = append(o.pending, entry)
+	if o.filename != "" {
+		if err := appendOutboxFile(o.filename, entry); err != nil {
+			logger.WithError(err).Error("Can't write to the outbox file.")
+		}
+	}
+	o.lock.Unlock()
+
+	o.notify()
+}
+
+func (o *outbox) notify() {
+	select {
+	case o.wakeup <- true:
+	default:
+	}
+}
+
+func (o *outbox) updateStatus(err error) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	if err != nil {
+		if !o.degraded {
+			logger.WithError(err).Error("Db is not available.")
+		}
+		o.degraded = true
+		o.lastError = err.Error()
+		return
+	}
+
+	if o.degraded && len(o.pending) == 0 {
+		logger.Info("Db is available again.")
+		o.degraded = false
+		o.lastError = ""
+	}
+}
+
+func (o *outbox) worker() {
+	backoff := outboxMinBackoff
+	for {
+		<-o.wakeup
+
+		for !o.flush() {
+			logger.WithField("retryIn", backoff).Warn("Can't write to the db, retrying later.")
+			time.Sleep(backoff)
+			backoff *= 2
+			if backoff > outboxMaxBackoff {
+				backoff = outboxMaxBackoff
+			}
+		}
+		backoff = outboxMinBackoff
+	}
+}
+
+// Writes all pending entries, returns false if the db failed.
+func (o *outbox) flush() bool {
+	written := 0
+	for {
+		o.lock.Lock()
+		if len(o.pending) == 0 {
+			o.lock.Unlock()
+			break
+		}
+		entry := o.pending[0]
+		o.lock.Unlock()
+
+		var err error
+		switch entry.Kind {
+		case OUTBOX_OPEN_STATE:
+			err = o.db.UpdateOpenState(entry.Place, state.OpenValueTs{Value: entry.Value, Timestamp: entry.Timestamp.Unix()})
+		case OUTBOX_DEVICES:
+			if entry.Devices != nil {
+				err = o.db.UpdateDevices(*entry.Devices)
+			}
+		case OUTBOX_POWER:
+			if entry.Power != nil {
+				err = o.db.UpdatePower(*entry.Power)
+			}
+		default:
+			logger.WithField("kind", entry.Kind).Warn("Dropping unknown outbox entry.")
+		}
+		if err != nil {
+			metrics.DbErrors.Inc(entry.Kind)
+			if !o.isRejected(err) {
+				o.updateStatus(err)
+				o.saveRemaining(written)
+				return false
+			}
+			o.reject(entry, err)
+		} else {
+			metrics.DbWrites.Inc(entry.Kind)
+		}
+
+		o.lock.Lock()
+		o.pending = o.pending[1:]
+		o.headAttempts = 0
+		o.lock.Unlock()
+		written++
+	}
+
+	o.updateStatus(nil)
+	o.saveRemaining(written)
+	return true
+}
+
+// A permanent error is rejected at once. Other errors are only counted while the db is reachable, a db outage never
+// rejects a write.
+func (o *outbox) isRejected(err error) bool {
+	if isPermanentDbError(err) {
+		return true
+	}
+	if o.db.Ping() != nil {
+		return false
+	}
+
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.headAttempts++
+	return o.headAttempts >= outboxMaxAttempts
+}
+
+func (o *outbox) reject(entry outboxEntry, err error) {
+	metrics.DbRejected.Inc(entry.Kind)
+	logger.WithError(err).WithField("kind", entry.Kind).WithField("timestamp", entry.Timestamp).
+		Error("The db doesn't accept the write, dropping it.")
+	if o.filename == "" {
+		return
+	}
+
+	entry.Error = err.Error()
+	if err := appendOutboxFile(o.filename+outboxRejectedSuffix, entry); err != nil {
+		logger.WithError(err).Error("Can't write to the file of the rejected writes.")
+	}
+}
+
+// data or constraint errors, repeating the write doesn't help
+func isPermanentDbError(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		switch mysqlErr.Number {
+		// null, duplicate key, out of range, invalid date, invalid value, too long, foreign key
+		case 1048, 1062, 1264, 1292, 1366, 1406, 1451, 1452:
+			return true
+		}
+		return false
+	}
+
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		switch sqliteErr.Code {
+		case sqlite3.ErrTooBig, sqlite3.ErrConstraint, sqlite3.ErrMismatch, sqlite3.ErrRange:
+			return true
+		}
+	}
+	return false
+}
+
+// rewrites the outbox file with the pending entries
+func (o *outbox) saveRemaining(written int) {
+	if o.filename == "" || written == 0 {
+		return
+	}
+
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	if err := writeOutboxFile(o.filename, o.pending); err != nil {
+		logger.WithError(err).Error("Can't update the outbox file.")
+	}
+}
+
+func loadOutboxFile(filename string) ([]outboxEntry, error) {
+	file, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	entries := make([]outboxEntry, 0)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry outboxEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			// e.g. a partly written last line
+			logger.WithError(err).Warn("Skipping invalid outbox entry.")
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, scanner.Err()
+}
+
+func appendOutboxFile(filename string, entry outboxEntry) error {
+	file, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := json.NewEncoder(file).Encode(entry); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+// replaces the file atomically
+func writeOutboxFile(filename string, entries []outboxEntry) error {
+	tmpFile, err := ioutil.TempFile(filepath.Dir(filename), ".outbox")
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(tmpFile)
+	for _, entry := range entries {
+		if err := encoder.Encode(entry); err != nil {
+			tmpFile.Close()
+			os.Remove(tmpFile.Name())
+			return err
+		}
+	}
+	if err := tmpFile.Sync(); err != nil {
+		tmpFile.Close()
+		os.Remove(tmpFile.Name())
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		os.Remove(tmpFile.Name())
+		return err
+	}
+
+	return os.Rename(tmpFile.Name(), filename)
+}
@@ -0,0 +1,206 @@
+package db
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/ktt-ol/status2/internal/state"
+	"github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/require"
+)
+
+// records the writes, fails while unavailable is set
+type unreliableDb struct {
+	DbManagerMock
+	lock        sync.Mutex
+	unavailable bool
+	openStates  []state.OpenValueTs
+	devices     []int64
+}
+
+func (db *unreliableDb) setUnavailable(unavailable bool) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	db.unavailable = unavailable
+}
+
+func (db *unreliableDb) GetLastOpenStates() ([]LastOpenStates, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	if db.unavailable {
+		return nil, errors.New("db down")
+	}
+	return nil, nil
+}
+
+func (db *unreliableDb) Ping() error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	if db.unavailable {
+		return errors.New("db down")
+	}
+	return nil
+}
+
+// a negative timestamp is rejected as permanent error, 0 as other error
+func (db *unreliableDb) UpdateOpenState(place Place, openValue state.OpenValueTs) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	if db.unavailable {
+		return errors.New("db down")
+	}
+	if openValue.Timestamp < 0 {
+		return &mysql.MySQLError{Number: 1062, Message: "Duplicate entry"}
+	}
+	if openValue.Timestamp == 0 {
+		return errors.New("invalid timestamp")
+	}
+	db.openStates = append(db.openStates, openValue)
+	return nil
+}
+
+func (db *unreliableDb) UpdateDevices(sample DevicesSample) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	if db.unavailable {
+		return errors.New("db down")
+	}
+	db.devices = append(db.devices, sample.Devices)
+	return nil
+}
+
+func (db *unreliableDb) writes() int {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	return len(db.openStates) + len(db.devices)
+}
+
+func waitForPending(t *testing.T, o *outbox, expected int) {
+	for i := 0; i < 50; i++ {
+		if o.Status().PendingWrites == expected {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	require.Equal(t, expected, o.Status().PendingWrites)
+}
+
+func Test_outbox(t *testing.T) {
+	dir, err := ioutil.TempDir("", "status2-outbox")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	outboxFile := filepath.Join(dir, "outbox.jsonl")
+
+	db := &unreliableDb{}
+	o := newOutbox(db, outboxFile)
+
+	require.Nil(t, o.UpdateOpenState(PLACE_SPACE, state.OpenValueTs{Value: state.OPEN, Timestamp: 1000}))
+	waitForPending(t, o, 0)
+	require.Equal(t, 1, db.writes())
+	require.False(t, o.Status().Degraded)
+
+	// db is down
+	db.setUnavailable(true)
+	require.Nil(t, o.UpdateOpenState(PLACE_SPACE, state.OpenValueTs{Value: state.NONE, Timestamp: 1100}))
+	require.Nil(t, o.UpdateDevices(DevicesSample{Timestamp: time.Unix(1200, 0), Devices: 5, People: 2}))
+	time.Sleep(100 * time.Millisecond)
+	status := o.Status()
+	require.True(t, status.Degraded)
+	require.Equal(t, 2, status.PendingWrites)
+	require.Equal(t, "db down", status.LastError)
+
+	_, err = o.GetLastOpenStates()
+	require.NotNil(t, err)
+
+	// the pending writes survive a restart
+	stored, err := loadOutboxFile(outboxFile)
+	require.Nil(t, err)
+	require.Len(t, stored, 2)
+	require.Equal(t, state.NONE, stored[0].Value)
+	require.Equal(t, int64(5), stored[1].Devices.Devices)
+
+	// db is back, the worker retries
+	db.setUnavailable(false)
+	waitForPending(t, o, 0)
+	require.False(t, o.Status().Degraded)
+	require.Equal(t, []state.OpenValueTs{{Value: state.OPEN, Timestamp: 1000}, {Value: state.NONE, Timestamp: 1100}}, db.openStates)
+	require.Equal(t, []int64{5}, db.devices)
+
+	stored, err = loadOutboxFile(outboxFile)
+	require.Nil(t, err)
+	require.Len(t, stored, 0)
+}
+
+func Test_outboxLoadsPendingWrites(t *testing.T) {
+	dir, err := ioutil.TempDir("", "status2-outbox")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	outboxFile := filepath.Join(dir, "outbox.jsonl")
+
+	require.Nil(t, appendOutboxFile(outboxFile, outboxEntry{Kind: OUTBOX_DEVICES, Devices: &DevicesSample{Devices: 3}, Timestamp: time.Unix(1000, 0)}))
+	// a partly written line
+	file, err := os.OpenFile(outboxFile, os.O_APPEND|os.O_WRONLY, 0644)
+	require.Nil(t, err)
+	file.WriteString(`{"kind": "dev`)
+	file.Close()
+
+	db := &unreliableDb{}
+	o := newOutbox(db, outboxFile)
+	waitForPending(t, o, 0)
+	require.Equal(t, []int64{3}, db.devices)
+}
+
+func Test_outboxRejectsWrites(t *testing.T) {
+	dir, err := ioutil.TempDir("", "status2-outbox")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	outboxFile := filepath.Join(dir, "outbox.jsonl")
+
+	db := &unreliableDb{}
+	// without the worker to control the retries
+	o := &outbox{db: db, filename: outboxFile, wakeup: make(chan bool, 1)}
+
+	// a permanent error is rejected at once, the later writes are not blocked
+	o.UpdateOpenState(PLACE_SPACE, state.OpenValueTs{Value: state.OPEN, Timestamp: -1})
+	o.UpdateOpenState(PLACE_SPACE, state.OpenValueTs{Value: state.NONE, Timestamp: 1000})
+	require.True(t, o.flush())
+	require.Equal(t, []state.OpenValueTs{{Value: state.NONE, Timestamp: 1000}}, db.openStates)
+
+	// other errors are retried while the db is reachable
+	o.UpdateOpenState(PLACE_SPACE, state.OpenValueTs{Value: state.OPEN, Timestamp: 0})
+	o.UpdateOpenState(PLACE_SPACE, state.OpenValueTs{Value: state.NONE, Timestamp: 1100})
+	for i := 1; i < outboxMaxAttempts; i++ {
+		require.False(t, o.flush())
+	}
+	// a db outage doesn't count
+	db.setUnavailable(true)
+	require.False(t, o.flush())
+	db.setUnavailable(false)
+	require.True(t, o.flush())
+	require.Equal(t, 0, o.Status().PendingWrites)
+	require.Len(t, db.openStates, 2)
+
+	rejected, err := loadOutboxFile(outboxFile + outboxRejectedSuffix)
+	require.Nil(t, err)
+	require.Len(t, rejected, 2)
+	require.Equal(t, int64(-1), rejected[0].Timestamp.Unix())
+	require.Equal(t, "Error 1062: Duplicate entry", rejected[0].Error)
+	require.Equal(t, "invalid timestamp", rejected[1].Error)
+	stored, err := loadOutboxFile(outboxFile)
+	require.Nil(t, err)
+	require.Len(t, stored, 0)
+}
+
+func Test_isPermanentDbError(t *testing.T) {
+	require.True(t, isPermanentDbError(&mysql.MySQLError{Number: 1062}))
+	require.False(t, isPermanentDbError(&mysql.MySQLError{Number: 1045}))
+	require.True(t, isPermanentDbError(sqlite3.Error{Code: sqlite3.ErrConstraint}))
+	require.False(t, isPermanentDbError(sqlite3.Error{Code: sqlite3.ErrBusy}))
+	require.False(t, isPermanentDbError(errors.New("connection refused")))
+}
@@ -3,21 +3,19 @@ package db
 import (
 	"github.com/ktt-ol/status2/internal/events"
 	"errors"
+	"github.com/ktt-ol/status2/internal/state"
 )
 
-type Place string
-
-func (p Place) StrValue() string {
-	return string(p)
-}
+type Place = state.Place
 
 const (
-	PLACE_SPACE     Place = "space"
-	PLACE_RADSTELLE Place = "radstelle"
-	PLACE_LAB3D     Place = "lab3d"
-	PLACE_MACHINING Place = "machining"
+	PLACE_SPACE     = state.PLACE_SPACE
+	PLACE_RADSTELLE = state.PLACE_RADSTELLE
+	PLACE_LAB3D     = state.PLACE_LAB3D
+	PLACE_MACHINING = state.PLACE_MACHINING
 )
 
+// the places stored in the db
 var validPlaces = [...]Place{PLACE_SPACE, PLACE_RADSTELLE, PLACE_LAB3D, PLACE_MACHINING}
 
 func IsValidPlace(place Place) bool {
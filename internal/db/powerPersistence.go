@@ -0,0 +1,140 @@
+package db
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ktt-ol/status2/internal/conf"
+	"github.com/ktt-ol/status2/internal/events"
+	"github.com/ktt-ol/status2/internal/state"
+)
+
+// Collects the power values of every meter and stores the average and maximum for each sample interval
+// (e.g. one minute).
+type PowerPersistence struct {
+	dbManager DbManager
+	st        *state.State
+	interval  time.Duration
+	now       func() time.Time
+
+	lock     sync.Mutex
+	buckets  map[state.PowerMeter]*powerBucket
+	lastSeen map[state.PowerMeter]state.PowerValueTs
+
+	stopChan chan bool
+	ticker   *time.Ticker
+}
+
+type powerBucket struct {
+	start time.Time
+	sum   float64
+	max   float64
+	count int
+}
+
+// Returns nil if the power persistence is disabled.
+func NewPowerPersistence(config conf.DbConf, dbManager DbManager, ev events.EventManager, st *state.State) *PowerPersistence {
+	if config.PowerSampleIntervalInSec <= 0 {
+		logger.Info("Power persistence is disabled.")
+		return nil
+	}
+
+	pp := newPowerPersistence(dbManager, st, time.Duration(config.PowerSampleIntervalInSec)*time.Second, time.Now)
+	ev.On(events.TOPIC_POWER_USAGE, pp.onChange)
+	pp.startTimer()
+	return pp
+}
+
+func newPowerPersistence(dbManager DbManager, st *state.State, interval time.Duration, now func() time.Time) *PowerPersistence {
+	return &PowerPersistence{
+		dbManager: dbManager,
+		st:        st,
+		interval:  interval,
+		now:       now,
+		buckets:   make(map[state.PowerMeter]*powerBucket),
+		lastSeen:  make(map[state.PowerMeter]state.PowerValueTs),
+		stopChan:  make(chan bool),
+	}
+}
+
+// the event doesn't tell which meter has changed, thus every meter with a new value is sampled
+func (pp *PowerPersistence) onChange(topic events.EventName) {
+	pp.lock.Lock()
+	defer pp.lock.Unlock()
+
+	bucketStart := pp.now().Truncate(pp.interval)
+	for _, meter := range state.AllPowerMeters {
+		value, _ := pp.st.PowerUsage.ForMeter(meter)
+		if value.Timestamp == 0 || *value == pp.lastSeen[meter] {
+			continue
+		}
+		pp.lastSeen[meter] = *value
+
+		bucket := pp.buckets[meter]
+		if bucket != nil && !bucket.start.Equal(bucketStart) {
+			pp.save(meter, bucket)
+			bucket = nil
+		}
+		if bucket == nil {
+			bucket = &powerBucket{start: bucketStart, max: value.Value}
+			pp.buckets[meter] = bucket
+		}
+
+		bucket.sum += value.Value
+		bucket.count++
+		if value.Value > bucket.max {
+			bucket.max = value.Value
+		}
+	}
+}
+
+// saves all buckets of past intervals, e.g. if a meter doesn't send any more values
+func (pp *PowerPersistence) saveFinishedBuckets() {
+	pp.lock.Lock()
+	defer pp.lock.Unlock()
+
+	currentStart := pp.now().Truncate(pp.interval)
+	for meter, bucket := range pp.buckets {
+		if bucket.start.Before(currentStart) {
+			pp.save(meter, bucket)
+			delete(pp.buckets, meter)
+		}
+	}
+}
+
+func (pp *PowerPersistence) save(meter state.PowerMeter, bucket *powerBucket) {
+	sample := PowerSample{
+		Meter:         meter,
+		Timestamp:     bucket.start,
+		Avg:           bucket.sum / float64(bucket.count),
+		Max:           bucket.max,
+		Samples:       bucket.count,
+		DurationInSec: int(pp.interval / time.Second),
+	}
+	if err := pp.dbManager.UpdatePower(sample); err != nil {
+		logger.WithError(err).Error("Can't update the power.")
+	}
+}
+
+func (pp *PowerPersistence) startTimer() {
+	logger.WithField("interval", pp.interval).Info("Starting PowerPersistence timer.")
+	pp.ticker = time.NewTicker(pp.interval)
+	go func() {
+		for {
+			select {
+			case <-pp.ticker.C:
+				pp.saveFinishedBuckets()
+			case <-pp.stopChan:
+				return
+			}
+		}
+	}()
+}
+
+func (pp *PowerPersistence) StopTimer() {
+	if pp.ticker != nil {
+		pp.ticker.Stop()
+		pp.ticker = nil
+		pp.stopChan <- true
+	}
+}
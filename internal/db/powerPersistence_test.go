@@ -0,0 +1,58 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ktt-ol/status2/internal/events"
+	"github.com/ktt-ol/status2/internal/state"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_PowerPersistence(t *testing.T) {
+	dbMock := new(DbManagerMock)
+	appState := state.NewDefaultState()
+	now := time.Date(2020, 1, 1, 10, 0, 10, 0, time.UTC)
+	pp := newPowerPersistence(dbMock, appState, time.Minute, func() time.Time { return now })
+
+	// no values yet
+	pp.onChange(events.TOPIC_POWER_USAGE)
+	pp.saveFinishedBuckets()
+	require.Len(t, dbMock.PowerSamples, 0)
+
+	setPower := func(value *state.PowerValueTs, watt float64) {
+		value.Value = watt
+		value.Timestamp = now.Unix()
+		pp.onChange(events.TOPIC_POWER_USAGE)
+	}
+
+	setPower(appState.PowerUsage.Front, 100)
+	now = now.Add(20 * time.Second)
+	setPower(appState.PowerUsage.Front, 300)
+	setPower(appState.PowerUsage.Back, 50)
+	// same value again, the event was for another meter
+	pp.onChange(events.TOPIC_POWER_USAGE)
+
+	// the interval is not finished
+	pp.saveFinishedBuckets()
+	require.Len(t, dbMock.PowerSamples, 0)
+
+	// a new value in the next interval saves the old one
+	now = now.Add(40 * time.Second)
+	setPower(appState.PowerUsage.Front, 10)
+	require.Len(t, dbMock.PowerSamples, 1)
+	require.Equal(t, PowerSample{Meter: state.POWER_METER_FRONT, Timestamp: time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC),
+		Avg: 200, Max: 300, Samples: 2, DurationInSec: 60}, dbMock.PowerSamples[0])
+
+	// the back meter doesn't send any more
+	pp.saveFinishedBuckets()
+	require.Len(t, dbMock.PowerSamples, 2)
+	require.Equal(t, PowerSample{Meter: state.POWER_METER_BACK, Timestamp: time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC),
+		Avg: 50, Max: 50, Samples: 1, DurationInSec: 60}, dbMock.PowerSamples[1])
+
+	now = now.Add(time.Minute)
+	pp.saveFinishedBuckets()
+	require.Len(t, dbMock.PowerSamples, 3)
+	require.Equal(t, 10.0, dbMock.PowerSamples[2].Avg)
+	require.Equal(t, time.Date(2020, 1, 1, 10, 1, 0, 0, time.UTC), dbMock.PowerSamples[2].Timestamp)
+}
@@ -0,0 +1,24 @@
+package db
+
+import (
+	"database/sql"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Opens (or creates) the sqlite db file. The tables are created by the migrations.
+func newSqliteManager(filename string) *dbManager {
+	if filename == "" {
+		logger.Fatal("The sqlite driver needs the sqliteFile config.")
+	}
+
+	db, err := sql.Open("sqlite3", filename+"?_busy_timeout=5000")
+	if err != nil {
+		logger.Fatal("Can't open sqlite db.", err)
+	}
+	// sqlite allows only one writer at once
+	db.SetMaxOpenConns(1)
+
+	logger.WithField("file", filename).Info("Using sqlite db.")
+	return &dbManager{db: db, driver: DRIVER_SQLITE}
+}
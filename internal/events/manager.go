@@ -1,6 +1,10 @@
 package events
 
-import "sync"
+import (
+	"sync"
+
+	"github.com/ktt-ol/status2/internal/metrics"
+)
 
 type EventHandler func(topic EventName)
 
@@ -45,6 +49,8 @@ func (em *eventManagerImpl) On(topic EventName, handler EventHandler) Registrati
 }
 
 func (em *eventManagerImpl) Emit(topic EventName) {
+	metrics.EventsEmitted.Inc(topic.StrValue())
+
 	em.lock.RLock()
 	defer em.lock.RUnlock()
 
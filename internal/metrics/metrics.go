@@ -0,0 +1,173 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	MqttMessages        = newCounterVec("status2_mqtt_messages_total", "Received mqtt messages.", "topic")
+	EventsEmitted       = newCounterVec("status2_events_emitted_total", "Emitted internal events.", "event")
+	DbWrites            = newCounterVec("status2_db_writes_total", "Successful db writes.", "kind")
+	DbErrors            = newCounterVec("status2_db_errors_total", "Failed db writes.", "kind")
+	DbRejected          = newCounterVec("status2_db_rejected_total", "Writes the db doesn't accept, they are dropped.", "kind")
+	NotificationsSent   = newCounterVec("status2_notifications_sent_total", "Sent notifications.", "channel")
+	NotificationsFailed = newCounterVec("status2_notifications_failed_total", "Notifications that could not be sent.", "channel")
+	SwitchLogins        = newCounterVec("status2_switch_logins_total", "Password attempts on the switch page.", "result")
+
+	SseClients = &Gauge{}
+)
+
+var counters []*CounterVec
+
+// A counter with one label in the Prometheus text format, safe for concurrent use.
+type CounterVec struct {
+	name  string
+	help  string
+	label string
+
+	lock   sync.Mutex
+	values map[string]uint64
+}
+
+func newCounterVec(name string, help string, label string) *CounterVec {
+	counter := &CounterVec{name: name, help: help, label: label, values: make(map[string]uint64)}
+	counters = append(counters, counter)
+	return counter
+}
+
+func (c *CounterVec) Inc(labelValue string) {
+	c.lock.Lock()
+	c.values[labelValue]++
+	c.lock.Unlock()
+}
+
+func (c *CounterVec) Value(labelValue string) uint64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.values[labelValue]
+}
+
+func (c *CounterVec) samples() []Sample {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	result := make([]Sample, 0, len(c.values))
+	for labelValue, value := range c.values {
+		result = append(result, Sample{Labels: []Label{{c.label, labelValue}}, Value: float64(value)})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Labels[0].Value < result[j].Labels[0].Value
+	})
+	return result
+}
+
+// A gauge that is changed by the code, e.g. for connections.
+type Gauge struct {
+	value int64
+}
+
+func (g *Gauge) Inc() {
+	atomic.AddInt64(&g.value, 1)
+}
+
+func (g *Gauge) Dec() {
+	atomic.AddInt64(&g.value, -1)
+}
+
+func (g *Gauge) Value() int64 {
+	return atomic.LoadInt64(&g.value)
+}
+
+type Label struct {
+	Name  string
+	Value string
+}
+
+type Sample struct {
+	Labels []Label
+	Value  float64
+}
+
+// Writes the metric families, the first error is kept and stops all further writes.
+type Writer struct {
+	writer io.Writer
+	err    error
+}
+
+func NewWriter(writer io.Writer) *Writer {
+	return &Writer{writer: writer}
+}
+
+func (w *Writer) Gauge(name string, help string, samples ...Sample) {
+	w.family(name, help, "gauge", samples)
+}
+
+func (w *Writer) Counter(name string, help string, samples ...Sample) {
+	w.family(name, help, "counter", samples)
+}
+
+// all counters of this package
+func (w *Writer) Counters() {
+	for _, counter := range counters {
+		w.Counter(counter.name, counter.help, counter.samples()...)
+	}
+}
+
+func (w *Writer) Err() error {
+	return w.err
+}
+
+func (w *Writer) family(name string, help string, metricType string, samples []Sample) {
+	w.printf("# HELP %s %s\n", name, strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help))
+	w.printf("# TYPE %s %s\n", name, metricType)
+	for _, sample := range samples {
+		w.printf("%s%s %s\n", name, formatLabels(sample.Labels), formatValue(sample.Value))
+	}
+}
+
+func (w *Writer) printf(format string, args ...interface{}) {
+	if w.err != nil {
+		return
+	}
+	_, w.err = fmt.Fprintf(w.writer, format, args...)
+}
+
+func formatLabels(labels []Label) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	escaper := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	parts := make([]string, len(labels))
+	for i, label := range labels {
+		parts[i] = label.Name + `="` + escaper.Replace(label.Value) + `"`
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// 1 for true, 0 otherwise
+func Bool(value bool) float64 {
+	if value {
+		return 1
+	}
+	return 0
+}
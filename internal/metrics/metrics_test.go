@@ -0,0 +1,58 @@
+package metrics
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Writer(t *testing.T) {
+	var buffer bytes.Buffer
+	w := NewWriter(&buffer)
+	w.Gauge("test_gauge", "A test\ngauge.",
+		Sample{Value: 1.5},
+		Sample{Labels: []Label{{"a", `x"y\z`}, {"b", "line\nbreak"}}, Value: math.Inf(1)})
+	w.Counter("test_counter", "A counter.")
+	require.Nil(t, w.Err())
+
+	require.Equal(t, `# HELP test_gauge A test\ngauge.
+# TYPE test_gauge gauge
+test_gauge 1.5
+test_gauge{a="x\"y\\z",b="line\nbreak"} +Inf
+# HELP test_counter A counter.
+# TYPE test_counter counter
+`, buffer.String())
+}
+
+func Test_CounterVec(t *testing.T) {
+	counter := &CounterVec{name: "test_total", help: "Test.", label: "topic", values: make(map[string]uint64)}
+	counter.Inc("b")
+	counter.Inc("a")
+	counter.Inc("b")
+	require.Equal(t, uint64(2), counter.Value("b"))
+	require.Equal(t, uint64(0), counter.Value("c"))
+
+	var buffer bytes.Buffer
+	NewWriter(&buffer).Counter(counter.name, counter.help, counter.samples()...)
+	require.Equal(t, `# HELP test_total Test.
+# TYPE test_total counter
+test_total{topic="a"} 1
+test_total{topic="b"} 2
+`, buffer.String())
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("closed")
+}
+
+func Test_Writer_error(t *testing.T) {
+	w := NewWriter(failingWriter{})
+	w.Gauge("test_gauge", "Test.", Sample{Value: 1})
+	w.Counters()
+	require.EqualError(t, w.Err(), "closed")
+}
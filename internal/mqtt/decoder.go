@@ -0,0 +1,166 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ktt-ol/status2/internal/conf"
+	"github.com/ktt-ol/status2/internal/state"
+)
+
+const (
+	DECODER_RAW  = "raw"
+	DECODER_JSON = "json"
+)
+
+// used for all topics without a decoder config
+var rawDecoder = &payloadDecoder{config: conf.PayloadDecoderConf{Type: DECODER_RAW}}
+
+// the power meters send their values in mW, unless the decoder config has an unit
+const DEFAULT_SOURCE_POWER_UNIT = state.MILLIWATT
+
+// used for the power topics without a decoder config
+var defaultPowerDecoder = &payloadDecoder{
+	config: conf.PayloadDecoderConf{Type: DECODER_RAW, Unit: string(DEFAULT_SOURCE_POWER_UNIT)}}
+
+// Extracts a single value from a mqtt payload, e.g. "123.4" or {"ENERGY": {"Power": 123.4}}
+type payloadDecoder struct {
+	config conf.PayloadDecoderConf
+}
+
+func newPayloadDecoder(config conf.PayloadDecoderConf) (*payloadDecoder, error) {
+	switch config.Type {
+	case "", DECODER_RAW:
+		if config.Path != "" || config.UnitPath != "" {
+			return nil, errors.New("path and unitPath are only supported for the json decoder")
+		}
+	case DECODER_JSON:
+		if config.Path == "" {
+			return nil, errors.New("the json decoder needs a path")
+		}
+	default:
+		return nil, errors.New("Unknown decoder type: " + config.Type)
+	}
+
+	return &payloadDecoder{config: config}, nil
+}
+
+// Returns the value as string. An empty payload (e.g. a deleted retained message) results in an empty string.
+func (d *payloadDecoder) decodeString(payload []byte) (string, error) {
+	if d.config.Type != DECODER_JSON {
+		return string(payload), nil
+	}
+	if len(payload) == 0 {
+		return "", nil
+	}
+
+	data, err := d.unmarshal(payload)
+	if err != nil {
+		return "", err
+	}
+	value, err := lookupJsonPath(data, d.config.Path)
+	if err != nil {
+		return "", err
+	}
+
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	default:
+		return "", fmt.Errorf("value at '%s' is not a simple value", d.config.Path)
+	}
+}
+
+// Returns the scaled value and its unit.
+func (d *payloadDecoder) decodeFloat(payload []byte) (float64, string, error) {
+	var value float64
+	unit := d.config.Unit
+
+	if d.config.Type == DECODER_JSON {
+		data, err := d.unmarshal(payload)
+		if err != nil {
+			return 0, "", err
+		}
+		rawValue, err := lookupJsonPath(data, d.config.Path)
+		if err != nil {
+			return 0, "", err
+		}
+		value, err = toFloat(rawValue)
+		if err != nil {
+			return 0, "", err
+		}
+
+		if d.config.UnitPath != "" {
+			if rawUnit, err := lookupJsonPath(data, d.config.UnitPath); err == nil {
+				if strUnit, ok := rawUnit.(string); ok && strUnit != "" {
+					unit = strUnit
+				}
+			}
+		}
+	} else {
+		var err error
+		value, err = strconv.ParseFloat(strings.TrimSpace(string(payload)), 64)
+		if err != nil {
+			return 0, "", err
+		}
+	}
+
+	if d.config.Scale != 0 {
+		value *= d.config.Scale
+	}
+
+	return value, unit, nil
+}
+
+func (d *payloadDecoder) unmarshal(payload []byte) (interface{}, error) {
+	var data interface{}
+	if err := json.Unmarshal(payload, &data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// Follows the dot separated path through the json data. Numeric path elements are used as array index.
+func lookupJsonPath(data interface{}, path string) (interface{}, error) {
+	current := data
+	for _, key := range strings.Split(path, ".") {
+		switch node := current.(type) {
+		case map[string]interface{}:
+			value, ok := node[key]
+			if !ok {
+				return nil, errors.New("Missing json key: " + key)
+			}
+			current = value
+		case []interface{}:
+			index, err := strconv.Atoi(key)
+			if err != nil || index < 0 || index >= len(node) {
+				return nil, errors.New("Invalid json array index: " + key)
+			}
+			current = node[index]
+		default:
+			return nil, fmt.Errorf("can't resolve '%s' in path '%s'", key, path)
+		}
+	}
+
+	return current, nil
+}
+
+func toFloat(value interface{}) (float64, error) {
+	switch v := value.(type) {
+	case float64:
+		return v, nil
+	case string:
+		return strconv.ParseFloat(strings.TrimSpace(v), 64)
+	default:
+		return 0, fmt.Errorf("not a number: %v", value)
+	}
+}
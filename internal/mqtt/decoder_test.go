@@ -0,0 +1,95 @@
+package mqtt
+
+import (
+	"testing"
+
+	"github.com/ktt-ol/status2/internal/conf"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_newPayloadDecoder(t *testing.T) {
+	_, err := newPayloadDecoder(conf.PayloadDecoderConf{})
+	require.Nil(t, err)
+	_, err = newPayloadDecoder(conf.PayloadDecoderConf{Type: DECODER_JSON, Path: "power"})
+	require.Nil(t, err)
+
+	_, err = newPayloadDecoder(conf.PayloadDecoderConf{Type: "xml"})
+	require.NotNil(t, err)
+	_, err = newPayloadDecoder(conf.PayloadDecoderConf{Type: DECODER_JSON})
+	require.NotNil(t, err)
+	_, err = newPayloadDecoder(conf.PayloadDecoderConf{Type: DECODER_RAW, Path: "power"})
+	require.NotNil(t, err)
+}
+
+func Test_decodeString(t *testing.T) {
+	value, err := rawDecoder.decodeString([]byte("open"))
+	require.Nil(t, err)
+	require.Equal(t, "open", value)
+
+	decoder, _ := newPayloadDecoder(conf.PayloadDecoderConf{Type: DECODER_JSON, Path: "state.value"})
+	value, err = decoder.decodeString([]byte(`{"state": {"value": "open+"}}`))
+	require.Nil(t, err)
+	require.Equal(t, "open+", value)
+
+	// empty payload, e.g. a removed retained message
+	value, err = decoder.decodeString([]byte(""))
+	require.Nil(t, err)
+	require.Equal(t, "", value)
+
+	value, err = decoder.decodeString([]byte(`{"state": {"value": 42}}`))
+	require.Nil(t, err)
+	require.Equal(t, "42", value)
+
+	_, err = decoder.decodeString([]byte(`{"state": {"other": "open"}}`))
+	require.NotNil(t, err)
+	_, err = decoder.decodeString([]byte(`{"state": {"value": {"a": 1}}}`))
+	require.NotNil(t, err)
+	_, err = decoder.decodeString([]byte(`{invalid`))
+	require.NotNil(t, err)
+}
+
+func Test_decodeFloat(t *testing.T) {
+	value, unit, err := defaultPowerDecoder.decodeFloat([]byte("123400"))
+	require.Nil(t, err)
+	require.Equal(t, 123400.0, value)
+	require.Equal(t, "mW", unit)
+
+	decoder, _ := newPayloadDecoder(conf.PayloadDecoderConf{Scale: 0.001})
+	value, unit, err = decoder.decodeFloat([]byte("123400"))
+	require.Nil(t, err)
+	require.InDelta(t, 123.4, value, 0.0001)
+	require.Equal(t, "", unit)
+
+	_, _, err = defaultPowerDecoder.decodeFloat([]byte("moin"))
+	require.NotNil(t, err)
+
+	decoder, _ = newPayloadDecoder(conf.PayloadDecoderConf{Type: DECODER_JSON, Path: "power", UnitPath: "unit", Unit: "kW"})
+	value, unit, err = decoder.decodeFloat([]byte(`{"power": 123.4, "unit": "W"}`))
+	require.Nil(t, err)
+	require.Equal(t, 123.4, value)
+	require.Equal(t, "W", unit)
+
+	// fallback to the configured unit
+	value, unit, err = decoder.decodeFloat([]byte(`{"power": "2.5"}`))
+	require.Nil(t, err)
+	require.Equal(t, 2.5, value)
+	require.Equal(t, "kW", unit)
+
+	// tasmota style
+	decoder, _ = newPayloadDecoder(conf.PayloadDecoderConf{Type: DECODER_JSON, Path: "ENERGY.Power", Scale: 2, Unit: "W"})
+	value, unit, err = decoder.decodeFloat([]byte(`{"Time":"2020-01-01T00:00:00","ENERGY":{"Total":1.2,"Power":50,"Voltage":230}}`))
+	require.Nil(t, err)
+	require.Equal(t, 100.0, value)
+	require.Equal(t, "W", unit)
+
+	// array index
+	decoder, _ = newPayloadDecoder(conf.PayloadDecoderConf{Type: DECODER_JSON, Path: "phases.1"})
+	value, _, err = decoder.decodeFloat([]byte(`{"phases": [1, 2, 3]}`))
+	require.Nil(t, err)
+	require.Equal(t, 2.0, value)
+
+	_, _, err = decoder.decodeFloat([]byte(`{"phases": [1]}`))
+	require.NotNil(t, err)
+	_, _, err = decoder.decodeFloat([]byte(`{"phases": ["a", "b"]}`))
+	require.NotNil(t, err)
+}
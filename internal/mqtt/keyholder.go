@@ -0,0 +1,25 @@
+package mqtt
+
+import (
+	"github.com/ktt-ol/status2/internal/conf"
+	"github.com/ktt-ol/status2/internal/state"
+)
+
+// Turns the keyholder id and name into the public display name.
+type keyholderResolver struct {
+	config conf.KeyholderConf
+}
+
+// Returns the alias for the id, if any. Otherwise the name, if unknown keyholders are not hidden.
+func (r *keyholderResolver) publicName(keyholder *state.KeyholderState) string {
+	if keyholder.Id != "" {
+		if alias, ok := r.config.Aliases[keyholder.Id]; ok {
+			return alias
+		}
+	}
+	if r.config.HideUnknown {
+		return ""
+	}
+
+	return keyholder.Name
+}
@@ -0,0 +1,75 @@
+package mqtt
+
+import (
+	"testing"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/ktt-ol/status2/internal/conf"
+	"github.com/ktt-ol/status2/internal/events"
+	"github.com/ktt-ol/status2/internal/state"
+	"github.com/ktt-ol/status2/internal/test"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_publicName(t *testing.T) {
+	resolver := &keyholderResolver{conf.KeyholderConf{Aliases: map[string]string{"42": "Hans", "23": ""}}}
+
+	require.Equal(t, "Hans", resolver.publicName(&state.KeyholderState{Id: "42", Name: "Hans Wurst"}))
+	// hidden
+	require.Equal(t, "", resolver.publicName(&state.KeyholderState{Id: "23", Name: "Secret"}))
+	// no alias
+	require.Equal(t, "Bob", resolver.publicName(&state.KeyholderState{Id: "1", Name: "Bob"}))
+	require.Equal(t, "Bob", resolver.publicName(&state.KeyholderState{Name: "Bob"}))
+
+	resolver.config.HideUnknown = true
+	require.Equal(t, "Hans", resolver.publicName(&state.KeyholderState{Id: "42", Name: "Hans Wurst"}))
+	require.Equal(t, "", resolver.publicName(&state.KeyholderState{Id: "1", Name: "Bob"}))
+}
+
+func Test_keyholderHandler(t *testing.T) {
+	eventsMock := new(test.EventManagerMock)
+	appState := state.NewDefaultState()
+	manager := MqttManager{state: appState, events: eventsMock,
+		keyholders: &keyholderResolver{conf.KeyholderConf{Aliases: map[string]string{"42": "Hans"}}}}
+	keyholder := appState.Open.Keyholder
+	idHandler := manager.keyholderHandler("/id", events.TOPIC_KEYHOLDER, keyholder, func(id string) {
+		keyholder.Id = id
+	})
+	nameHandler := manager.keyholderHandler("/name", events.TOPIC_KEYHOLDER, keyholder, func(name string) {
+		keyholder.Name = name
+	})
+
+	nameHandler(nil, &test.MessageMock{PayloadData: []byte("Hans Wurst")})
+	require.Equal(t, "Hans Wurst", keyholder.Public)
+	idHandler(nil, &test.MessageMock{PayloadData: []byte("42")})
+	require.Equal(t, "42", keyholder.Id)
+	require.Equal(t, "Hans Wurst", keyholder.Name)
+	require.Equal(t, "Hans", keyholder.Public)
+	require.Equal(t, 2, eventsMock.EmitCount)
+	require.Equal(t, events.TOPIC_KEYHOLDER, eventsMock.LastEvent)
+
+	// an empty message clears the id, the alias doesn't apply anymore
+	idHandler(nil, &test.MessageMock{PayloadData: []byte("")})
+	require.Equal(t, "", keyholder.Id)
+	require.Equal(t, "Hans Wurst", keyholder.Public)
+	require.Equal(t, 3, eventsMock.EmitCount)
+
+	// the keyholder left
+	nameHandler(nil, &test.MessageMock{PayloadData: []byte("")})
+	require.Equal(t, state.KeyholderState{}, *keyholder)
+	require.Equal(t, 4, eventsMock.EmitCount)
+}
+
+func Test_subscribeToKeyholderState_emptyTopic(t *testing.T) {
+	appState := state.NewDefaultState()
+	// without a client (replay mode) the handlers are only registered
+	manager := MqttManager{state: appState, events: new(test.EventManagerMock),
+		handlers: make(map[string]mqtt.MessageHandler), keyholders: &keyholderResolver{}}
+
+	manager.subscribeToKeyholderState("", "/name", events.TOPIC_KEYHOLDER_MACHINING, appState.Open.KeyholderMachining)
+	require.Len(t, manager.handlers, 1)
+	require.Contains(t, manager.handlers, "/name")
+
+	manager.subscribeToKeyholderState("", "", events.TOPIC_KEYHOLDER_WOODWORKING, appState.Open.KeyholderWoodworking)
+	require.Len(t, manager.handlers, 1)
+}
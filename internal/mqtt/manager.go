@@ -4,8 +4,8 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"io/ioutil"
-	"strconv"
 	"time"
 
 	"github.com/bep/debounce"
@@ -13,6 +13,7 @@ import (
 	"github.com/ktt-ol/spaceDevices/pkg/structs"
 	"github.com/ktt-ol/status2/internal/conf"
 	"github.com/ktt-ol/status2/internal/events"
+	"github.com/ktt-ol/status2/internal/metrics"
 	"github.com/ktt-ol/status2/internal/state"
 	"github.com/sirupsen/logrus"
 )
@@ -30,10 +31,19 @@ type MqttManager struct {
 	lastOpenState     *state.OpenValueTs
 	lastOpenStateNext *state.OpenValueTs
 	debounceFunc      func(f func())
+	// payload decoders by topic
+	decoders map[string]*payloadDecoder
+	// all subscribed handlers by topic, used for the replay
+	handlers map[string]mqtt.MessageHandler
+	// optional, records all received messages
+	recorder *recorder
+	// public names of the keyholders
+	keyholders *keyholderResolver
 	//watchDog     *watchDog
 }
 
-func NewMqttManager(conf conf.MqttConf, events events.EventManager, appState *state.State) *MqttManager {
+func NewMqttManager(conf conf.MqttConf, keyholderConf conf.KeyholderConf, events events.EventManager,
+	appState *state.State) *MqttManager {
 	opts := mqtt.NewClientOptions()
 
 	opts.AddBroker(conf.Url)
@@ -56,14 +66,15 @@ func NewMqttManager(conf conf.MqttConf, events events.EventManager, appState *st
 	opts.SetKeepAlive(10 * time.Second)
 	opts.SetMaxReconnectInterval(5 * time.Minute)
 
-	debounced, _, _ := debounce.New(500 * time.Millisecond)
-	handler := MqttManager{
-		config:            conf,
-		events:            events,
-		state:             appState,
-		lastOpenState:     nil,
-		lastOpenStateNext: nil,
-		debounceFunc:      debounced,
+	handler := newManager(conf, keyholderConf, events, appState)
+
+	if conf.RecordFile != "" {
+		rec, err := newRecorder(conf.RecordFile)
+		if err != nil {
+			mqttLogger.WithError(err).Fatal("Could not open the record file.")
+		}
+		mqttLogger.WithField("recordFile", conf.RecordFile).Info("Recording all mqtt messages.")
+		handler.recorder = rec
 	}
 
 	opts.SetOnConnectHandler(handler.onConnect)
@@ -79,20 +90,114 @@ func NewMqttManager(conf conf.MqttConf, events events.EventManager, appState *st
 	//	handler.watchDog = NewWatchDog(time.Duration(conf.WatchDogTimeoutInMinutes) * time.Minute)
 	//}
 
-	return &handler
+	return handler
+}
+
+// Creates a manager without any broker connection. The messages must be fed with Replay.
+func NewReplayMqttManager(conf conf.MqttConf, keyholderConf conf.KeyholderConf, events events.EventManager,
+	appState *state.State) *MqttManager {
+	handler := newManager(conf, keyholderConf, events, appState)
+	// registers all handlers
+	handler.onConnect(nil)
+
+	return handler
+}
+
+func newManager(conf conf.MqttConf, keyholderConf conf.KeyholderConf, events events.EventManager,
+	appState *state.State) *MqttManager {
+	decoders := make(map[string]*payloadDecoder)
+	for topic, decoderConf := range conf.Decoders {
+		decoder, err := newPayloadDecoder(decoderConf)
+		if err != nil {
+			mqttLogger.WithField("topic", topic).WithError(err).Fatal("Invalid decoder config.")
+		}
+		decoders[topic] = decoder
+	}
+
+	debounced, _, _ := debounce.New(500 * time.Millisecond)
+	return &MqttManager{
+		config:            conf,
+		events:            events,
+		state:             appState,
+		lastOpenState:     nil,
+		lastOpenStateNext: nil,
+		debounceFunc:      debounced,
+		decoders:          decoders,
+		handlers:          make(map[string]mqtt.MessageHandler),
+		keyholders:        &keyholderResolver{keyholderConf},
+	}
+}
+
+// the mqtt topics of a place, empty if not available for the place
+type placeTopics struct {
+	state         string
+	stateNext     string
+	keyholderId   string
+	keyholderName string
 }
 
-func (h *MqttManager) SendNewSpaceStatus(status state.OpenValue) {
-	stLogger := mqttLogger.WithField("newStatus", status)
-	stLogger.Info("Sending new space status mqtt value.")
+func (h *MqttManager) topicsForPlace(place state.Place) (placeTopics, error) {
+	topics := h.config.Topics
+	switch place {
+	case state.PLACE_SPACE:
+		return placeTopics{topics.StateSpace, topics.StateSpaceNext, topics.KeyholderId, topics.KeyholderName}, nil
+	case state.PLACE_RADSTELLE:
+		return placeTopics{state: topics.StateRadstelle}, nil
+	case state.PLACE_LAB3D:
+		return placeTopics{state: topics.StateLab3d}, nil
+	case state.PLACE_MACHINING:
+		return placeTopics{state: topics.StateMachining, keyholderId: topics.KeyholderIdMachining,
+			keyholderName: topics.KeyholderNameMachining}, nil
+	case state.PLACE_WOODWORKING:
+		return placeTopics{state: topics.StateWoodworking, keyholderId: topics.KeyholderIdWoodworking,
+			keyholderName: topics.KeyholderNameWoodworking}, nil
+	default:
+		return placeTopics{}, errors.New("Unknown place: " + place.StrValue())
+	}
+}
+
+// Publishes the new open state of the place as retained message. The keyholder and the next state of the place are
+// cleared, because we don't know them anymore.
+func (h *MqttManager) PublishOpenState(place state.Place, value state.OpenValue) error {
+	if parsed, err := state.ParseOpenValue(string(value)); err != nil || parsed != value {
+		return errors.New("Invalid open value: " + string(value))
+	}
+	if value == state.CLOSING {
+		return errors.New("The closing state can't be published, it's calculated from the next state.")
+	}
+
+	topics, err := h.topicsForPlace(place)
+	if err != nil {
+		return err
+	}
+	if topics.state == "" {
+		return errors.New("No state topic configured for place: " + place.StrValue())
+	}
+
+	mqttLogger.WithField("place", place).WithField("newStatus", value).Info("Sending new open state mqtt value.")
+
+	if !h.publish(topics.state, string(value)) {
+		return errors.New("Can't publish the new state.")
+	}
+	ok := true
+	for _, topic := range []string{topics.stateNext, topics.keyholderName, topics.keyholderId} {
+		if topic != "" {
+			ok = h.publish(topic, "") && ok
+		}
+	}
+	if !ok {
+		return errors.New("Can't reset the keyholder or next state.")
+	}
 
-	h.publish(h.config.Topics.StateSpace, string(status))
-	// reset the keyholder, because we don't this anymore
-	h.publish(h.config.Topics.KeyholderName, "")
-	h.publish(h.config.Topics.KeyholderId, "")
+	return nil
 }
 
 func (h *MqttManager) publish(topic string, value string) bool {
+	if h.client == nil {
+		mqttLogger.WithField("topic", topic).WithField("value", value).Warn("Replay mode, not publishing.")
+		return false
+	}
+
 	token := h.client.Publish(topic, 0, true, value)
 	if token.WaitTimeout(5 * time.Second) {
 		// no timeout, but there might be an error
@@ -134,9 +239,12 @@ func (h *MqttManager) onConnect(client mqtt.Client) {
 	h.subscribeToPower(h.config.Topics.EnergyBack, events.TOPIC_POWER_USAGE, h.state.PowerUsage.Back)
 	h.subscribeToPower(h.config.Topics.EnergyMachining, events.TOPIC_POWER_USAGE, h.state.PowerUsage.Machining)
 
-	h.subscribeToKeyholderState(h.config.Topics.KeyholderName, events.TOPIC_KEYHOLDER, &h.state.Open.Keyholder)
-	h.subscribeToKeyholderState(h.config.Topics.KeyholderNameMachining, events.TOPIC_KEYHOLDER_MACHINING, &h.state.Open.KeyholderMachining)
-	h.subscribeToKeyholderState(h.config.Topics.KeyholderNameWoodworking, events.TOPIC_KEYHOLDER_WOODWORKING, &h.state.Open.KeyholderWoodworking)
+	h.subscribeToKeyholderState(h.config.Topics.KeyholderId, h.config.Topics.KeyholderName,
+		events.TOPIC_KEYHOLDER, h.state.Open.Keyholder)
+	h.subscribeToKeyholderState(h.config.Topics.KeyholderIdMachining, h.config.Topics.KeyholderNameMachining,
+		events.TOPIC_KEYHOLDER_MACHINING, h.state.Open.KeyholderMachining)
+	h.subscribeToKeyholderState(h.config.Topics.KeyholderIdWoodworking, h.config.Topics.KeyholderNameWoodworking,
+		events.TOPIC_KEYHOLDER_WOODWORKING, h.state.Open.KeyholderWoodworking)
 
 	h.subscribe(h.config.Topics.BackdoorBoltContact, h.onBackdoorBoltContactChange)
 }
@@ -149,6 +257,25 @@ func (h *MqttManager) onConnectionLost(client mqtt.Client, err error) {
 }
 
 func (h *MqttManager) subscribe(topic string, cb mqtt.MessageHandler) {
+	counted := cb
+	cb = func(client mqtt.Client, message mqtt.Message) {
+		metrics.MqttMessages.Inc(topic)
+		counted(client, message)
+	}
+	if h.recorder != nil {
+		handler := cb
+		cb = func(client mqtt.Client, message mqtt.Message) {
+			h.recorder.record(message)
+			handler(client, message)
+		}
+	}
+	h.handlers[topic] = cb
+
+	if h.client == nil {
+		// replay mode
+		return
+	}
+
 	qos := 0
 	tok := h.client.Subscribe(topic, byte(qos), cb)
 	tok.WaitTimeout(5 * time.Second)
@@ -158,14 +285,29 @@ func (h *MqttManager) subscribe(topic string, cb mqtt.MessageHandler) {
 	}
 }
 
+// returns the configured decoder for the topic or the given fallback
+func (h *MqttManager) decoderFor(topic string, fallback *payloadDecoder) *payloadDecoder {
+	if decoder, ok := h.decoders[topic]; ok {
+		return decoder
+	}
+	return fallback
+}
+
 // subscribe to an open state change (e.g. radstelle)
 // on event does: parse the new open state, change the value in the state and emit the event
 func (h *MqttManager) subscribeToOpenState(topic string, eventName events.EventName, openState *state.OpenValueTs) {
+	h.subscribe(topic, h.openStateHandler(topic, eventName, openState))
+}
 
-	h.subscribe(topic, func(client mqtt.Client, message mqtt.Message) {
+func (h *MqttManager) openStateHandler(topic string, eventName events.EventName, openState *state.OpenValueTs) mqtt.MessageHandler {
+	return func(client mqtt.Client, message mqtt.Message) {
 		topicLogger := mqttLogger.WithField("topic", topic)
 
-		strMessage := string(message.Payload())
+		strMessage, err := h.decoderFor(topic, rawDecoder).decodeString(message.Payload())
+		if err != nil {
+			topicLogger.WithError(err).Warn("Can't decode open state payload.")
+			return
+		}
 		if strMessage == "" {
 			topicLogger.Debug("Empty message.")
 			return
@@ -176,60 +318,107 @@ func (h *MqttManager) subscribeToOpenState(topic string, eventName events.EventN
 			return
 		}
 
-		topicLogger.WithField("state", openValue).Info("new open state")
+		topicLogger.WithField("state", openValue).WithField("retained", message.Retained()).Info("new open state")
 
-		openState.Value = openValue
-		openState.Timestamp = time.Now().Unix()
+		*openState = *newOpenValueTs(openValue, message.Retained(), openState)
 		h.events.Emit(eventName)
-	})
+	}
+}
+
+// Creates the new open value with the current time. A retained message (e.g. after a reconnect) is marked as
+// restored and keeps the timestamp of the known value, if the value hasn't changed.
+func newOpenValueTs(value state.OpenValue, retained bool, known *state.OpenValueTs) *state.OpenValueTs {
+	newValue := &state.OpenValueTs{Value: value, Timestamp: time.Now().Unix(), Restored: retained}
+	if retained && known != nil && known.Value == value && known.Timestamp != 0 {
+		newValue.Timestamp = known.Timestamp
+	}
+
+	return newValue
 }
 
-func (h *MqttManager) subscribeToKeyholderState(topic string, eventName events.EventName, state *string) {
-	h.subscribe(topic, func(client mqtt.Client, message mqtt.Message) {
+func (h *MqttManager) subscribeToKeyholderState(idTopic string, nameTopic string, eventName events.EventName,
+	keyholder *state.KeyholderState) {
+	// the topics of the workshops are optional, older configs don't have them
+	if idTopic == "" {
+		mqttLogger.WithField("eventName", eventName).Info("No keyholder id topic configured, not subscribing.")
+	} else {
+		h.subscribe(idTopic, h.keyholderHandler(idTopic, eventName, keyholder, func(id string) {
+			keyholder.Id = id
+		}))
+	}
+	if nameTopic == "" {
+		mqttLogger.WithField("eventName", eventName).Info("No keyholder name topic configured, not subscribing.")
+	} else {
+		h.subscribe(nameTopic, h.keyholderHandler(nameTopic, eventName, keyholder, func(name string) {
+			keyholder.Name = name
+		}))
+	}
+}
+
+// on event does: decode the id or name, set it via the setter, resolve the public name and emit the event. An empty
+// payload clears the id or name.
+func (h *MqttManager) keyholderHandler(topic string, eventName events.EventName, keyholder *state.KeyholderState,
+	setter func(value string)) mqtt.MessageHandler {
+	return func(client mqtt.Client, message mqtt.Message) {
 		topicLogger := mqttLogger.WithField("topic", topic)
-		keyholder := string(message.Payload())
-		if keyholder == "" {
-			topicLogger.Debug("Empty message")
+		value, err := h.decoderFor(topic, rawDecoder).decodeString(message.Payload())
+		if err != nil {
+			topicLogger.WithError(err).Warn("Can't decode keyholder payload.")
 			return
 		}
-
-		topicLogger.WithField("keyholder", keyholder).Info("Set new keyholder.")
-		*state = keyholder
-		logrus.WithField("keyholder", keyholder).WithField("eventName", eventName).Info("setting new keyholder state")
+		// an empty message, e.g. a removed retained message, clears the id or name
+		setter(value)
+		keyholder.Public = h.keyholders.publicName(keyholder)
+		// the id and name are not logged, only the public name
+		topicLogger.WithField("keyholder", keyholder.Public).WithField("eventName", eventName).Info("setting new keyholder state")
 		h.events.Emit(eventName)
-	})
+	}
 }
 
 // subscribe to a power state change(e.g. front/back)
-// on event does: parse the new open state, change the value in the state and emit the event
+// on event does: decode the new power value, change the value in the state and emit the event
 func (h *MqttManager) subscribeToPower(topic string, eventName events.EventName, powerState *state.PowerValueTs) {
+	h.subscribe(topic, h.powerHandler(topic, eventName, powerState))
+}
 
-	h.subscribe(topic, func(client mqtt.Client, message mqtt.Message) {
-		strMessage := string(message.Payload())
-
-		energy, err := strconv.ParseFloat(strMessage, 64)
+func (h *MqttManager) powerHandler(topic string, eventName events.EventName, powerState *state.PowerValueTs) mqtt.MessageHandler {
+	return func(client mqtt.Client, message mqtt.Message) {
+		energy, unitStr, err := h.decoderFor(topic, defaultPowerDecoder).decodeFloat(message.Payload())
 		if err != nil {
-			mqttLogger.WithError(err).WithField("topic", topic).Warn("Invalid float value for power: ", strMessage)
+			mqttLogger.WithError(err).WithField("topic", topic).Warn("Invalid power value: ", string(message.Payload()))
 			return
 		}
 
-		energy /= 1000
+		// without an unit the value is in mW, with or without a decoder
+		sourceUnit := DEFAULT_SOURCE_POWER_UNIT
+		if unitStr != "" {
+			sourceUnit, err = state.ParsePowerUnit(unitStr)
+			if err != nil {
+				mqttLogger.WithError(err).WithField("topic", topic).Warn("Invalid power unit.")
+				return
+			}
+		}
 
 		//mqttLogger.WithFields(logrus.Fields{
 		//	"topic": topic,
 		//	"state": strMessage,
 		//}).Debug("new power state")
 
-		powerState.Value = energy
+		powerState.Value = state.ConvertPower(energy, sourceUnit, state.DEFAULT_POWER_UNIT)
+		powerState.Unit = state.DEFAULT_POWER_UNIT
 		powerState.Timestamp = time.Now().Unix()
 		h.events.Emit(eventName)
-	})
+	}
 }
 
 func (h *MqttManager) onSpaceOpenChange(client mqtt.Client, message mqtt.Message) {
 	topicLogger := mqttLogger.WithField("topic", message.Topic())
 
-	strMessage := string(message.Payload())
+	strMessage, err := h.decoderFor(message.Topic(), rawDecoder).decodeString(message.Payload())
+	if err != nil {
+		topicLogger.WithError(err).Warn("Can't decode open state payload.")
+		return
+	}
 	if strMessage == "" {
 		// the open-next can be unset...
 		if message.Topic() == h.config.Topics.StateSpaceNext {
@@ -250,13 +439,18 @@ func (h *MqttManager) onSpaceOpenChange(client mqtt.Client, message mqtt.Message
 	topicLogger.WithField("openValue", openValue).Info("onSpaceOpenChange")
 
 	if message.Topic() == h.config.Topics.StateSpace {
-		h.lastOpenState = &state.OpenValueTs{Value: openValue, Timestamp: time.Now().Unix()}
+		known := h.lastOpenState
+		if known == nil {
+			// e.g. restored from the db
+			known = h.state.Open.Space
+		}
+		h.lastOpenState = newOpenValueTs(openValue, message.Retained(), known)
 		h.debounceFunc(h.newSpaceState)
 		return
 	}
 
 	if message.Topic() == h.config.Topics.StateSpaceNext {
-		h.lastOpenStateNext = &state.OpenValueTs{Value: openValue, Timestamp: time.Now().Unix()}
+		h.lastOpenStateNext = newOpenValueTs(openValue, message.Retained(), h.lastOpenStateNext)
 		h.debounceFunc(h.newSpaceState)
 		return
 	}
@@ -318,7 +512,7 @@ func (h *MqttManager) newSpaceState() {
 		Debug("newSpaceState.")
 
 	if !h.lastOpenState.Value.IsPublicOpen() {
-		h.changeOpenState(h.lastOpenState.Value, h.lastOpenState.Timestamp)
+		h.changeOpenState(*h.lastOpenState)
 		return
 	}
 
@@ -326,22 +520,23 @@ func (h *MqttManager) newSpaceState() {
 		// is the next state close for guests?
 		nextValue := h.lastOpenStateNext.Value
 		if nextValue == state.NONE || nextValue == state.KEYHOLDER || nextValue == state.MEMBER {
-			h.changeOpenState(state.CLOSING, time.Now().Unix())
+			restored := h.lastOpenState.Restored && h.lastOpenStateNext.Restored
+			h.changeOpenState(*newOpenValueTs(state.CLOSING, restored, h.state.Open.Space))
 			return
 		}
 	}
 	// no special closing state
-	h.changeOpenState(h.lastOpenState.Value, h.lastOpenState.Timestamp)
+	h.changeOpenState(*h.lastOpenState)
 }
 
 // changes the state, logs and emits the event
-func (h *MqttManager) changeOpenState(value state.OpenValue, timestamp int64) {
+func (h *MqttManager) changeOpenState(newValue state.OpenValueTs) {
 	mqttLogger.WithFields(logrus.Fields{
-		"state": value,
+		"state":    newValue.Value,
+		"restored": newValue.Restored,
 	}).Info("new SPACE open state")
 
-	h.state.Open.Space.Value = value
-	h.state.Open.Space.Timestamp = timestamp
+	*h.state.Open.Space = newValue
 	h.events.Emit(events.TOPIC_SPACE_OPEN_STATE)
 }
 
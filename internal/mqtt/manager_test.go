@@ -3,6 +3,7 @@ package mqtt
 import (
 	"testing"
 	"github.com/stretchr/testify/require"
+	"github.com/ktt-ol/status2/internal/conf"
 	"github.com/ktt-ol/status2/internal/events"
 	"github.com/ktt-ol/status2/internal/state"
 	"github.com/ktt-ol/status2/internal/test"
@@ -88,3 +89,127 @@ func Test_onDevicesChange(t *testing.T) {
 	manager.onDevicesChange(nil, mMock)
 	require.Equal(t, 3, eventsMock.EmitCount)
 }
+
+func Test_powerHandler(t *testing.T) {
+	eventsMock := new(test.EventManagerMock)
+	appState := state.NewDefaultState()
+	manager := MqttManager{state: appState, events: eventsMock, decoders: map[string]*payloadDecoder{
+		"/json": {config: conf.PayloadDecoderConf{Type: DECODER_JSON, Path: "power", UnitPath: "unit"}},
+		"/kw":   {config: conf.PayloadDecoderConf{Unit: "kW"}},
+		"/none": {config: conf.PayloadDecoderConf{}},
+		"/xyz":  {config: conf.PayloadDecoderConf{Unit: "xyz"}},
+	}}
+	powerState := appState.PowerUsage.Front
+	mMock := new(test.MessageMock)
+
+	// legacy meters send mW
+	manager.powerHandler("/legacy", events.TOPIC_POWER_USAGE, powerState)(nil, mMock.WithPayload("123400"))
+	require.InDelta(t, 123.4, powerState.Value, 0.0001)
+	require.Equal(t, state.WATT, powerState.Unit)
+	require.Equal(t, 1, eventsMock.EmitCount)
+	require.Equal(t, events.TOPIC_POWER_USAGE, eventsMock.LastEvent)
+
+	manager.powerHandler("/json", events.TOPIC_POWER_USAGE, powerState)(nil, mMock.WithPayload(`{"power": 1.5, "unit": "kW"}`))
+	require.Equal(t, 1500.0, powerState.Value)
+	require.Equal(t, state.WATT, powerState.Unit)
+
+	manager.powerHandler("/kw", events.TOPIC_POWER_USAGE, powerState)(nil, mMock.WithPayload("0.25"))
+	require.Equal(t, 250.0, powerState.Value)
+
+	// no unit means mW, like without a decoder
+	manager.powerHandler("/none", events.TOPIC_POWER_USAGE, powerState)(nil, mMock.WithPayload("42000"))
+	require.Equal(t, 42.0, powerState.Value)
+	require.Equal(t, 4, eventsMock.EmitCount)
+
+	// errors don't change the state
+	manager.powerHandler("/xyz", events.TOPIC_POWER_USAGE, powerState)(nil, mMock.WithPayload("1"))
+	manager.powerHandler("/none", events.TOPIC_POWER_USAGE, powerState)(nil, mMock.WithPayload("moin"))
+	require.Equal(t, 42.0, powerState.Value)
+	require.Equal(t, 4, eventsMock.EmitCount)
+}
+
+func Test_openStateHandler_retained(t *testing.T) {
+	eventsMock := new(test.EventManagerMock)
+	appState := state.NewDefaultState()
+	manager := MqttManager{state: appState, events: eventsMock}
+	// e.g. restored from the db
+	appState.Open.Radstelle.Value = state.OPEN
+	appState.Open.Radstelle.Timestamp = 1234
+	handler := manager.openStateHandler("/radstelle", events.TOPIC_RADSTELLE_OPEN_STATE, appState.Open.Radstelle)
+
+	// the retained message keeps the known timestamp
+	handler(nil, &test.MessageMock{PayloadData: []byte("open"), RetainedData: true})
+	require.Equal(t, state.OPEN, appState.Open.Radstelle.Value)
+	require.Equal(t, int64(1234), appState.Open.Radstelle.Timestamp)
+	require.True(t, appState.Open.Radstelle.Restored)
+	require.Equal(t, 1, eventsMock.EmitCount)
+
+	// a retained message with a different value, we don't know when it was changed
+	handler(nil, &test.MessageMock{PayloadData: []byte("none"), RetainedData: true})
+	require.Equal(t, state.NONE, appState.Open.Radstelle.Value)
+	require.NotEqual(t, int64(1234), appState.Open.Radstelle.Timestamp)
+	require.True(t, appState.Open.Radstelle.Restored)
+
+	// a normal change
+	appState.Open.Radstelle.Timestamp = 1234
+	handler(nil, &test.MessageMock{PayloadData: []byte("none")})
+	require.NotEqual(t, int64(1234), appState.Open.Radstelle.Timestamp)
+	require.False(t, appState.Open.Radstelle.Restored)
+	require.Equal(t, 3, eventsMock.EmitCount)
+}
+
+func Test_onSpaceOpenChange_retained(t *testing.T) {
+	eventsMock := new(test.EventManagerMock)
+	appState := state.NewDefaultState()
+	manager := MqttManager{state: appState, events: eventsMock,
+		config:       conf.MqttConf{Topics: conf.MqttTopicsConf{StateSpace: "/space", StateSpaceNext: "/next"}},
+		debounceFunc: func(f func()) { f() },
+	}
+	appState.Open.Space.Value = state.OPEN
+	appState.Open.Space.Timestamp = 1234
+
+	manager.onSpaceOpenChange(nil, &test.MessageMock{TopicData: "/space", PayloadData: []byte("open"), RetainedData: true})
+	require.Equal(t, state.OPEN, appState.Open.Space.Value)
+	require.Equal(t, int64(1234), appState.Open.Space.Timestamp)
+	require.True(t, appState.Open.Space.Restored)
+
+	// the space is closing, known since 2345
+	appState.Open.Space.Value = state.CLOSING
+	appState.Open.Space.Timestamp = 2345
+	manager.onSpaceOpenChange(nil, &test.MessageMock{TopicData: "/next", PayloadData: []byte("none"), RetainedData: true})
+	require.Equal(t, state.CLOSING, appState.Open.Space.Value)
+	require.Equal(t, int64(2345), appState.Open.Space.Timestamp)
+	require.True(t, appState.Open.Space.Restored)
+
+	// not retained -> a real change
+	manager.onSpaceOpenChange(nil, &test.MessageMock{TopicData: "/space", PayloadData: []byte("none")})
+	require.Equal(t, state.NONE, appState.Open.Space.Value)
+	require.NotEqual(t, int64(2345), appState.Open.Space.Timestamp)
+	require.False(t, appState.Open.Space.Restored)
+	require.Equal(t, 3, eventsMock.EmitCount)
+}
+
+func Test_PublishOpenState(t *testing.T) {
+	manager := MqttManager{config: conf.MqttConf{Topics: conf.MqttTopicsConf{
+		StateSpace: "/space", StateSpaceNext: "/next", KeyholderId: "/id", KeyholderName: "/name",
+		StateMachining: "/machining", KeyholderNameMachining: "/machining/name",
+	}}}
+
+	topics, err := manager.topicsForPlace(state.PLACE_SPACE)
+	require.Nil(t, err)
+	require.Equal(t, placeTopics{"/space", "/next", "/id", "/name"}, topics)
+	topics, err = manager.topicsForPlace(state.PLACE_MACHINING)
+	require.Nil(t, err)
+	require.Equal(t, placeTopics{state: "/machining", keyholderName: "/machining/name"}, topics)
+	_, err = manager.topicsForPlace(state.Place("kitchen"))
+	require.NotNil(t, err)
+
+	require.NotNil(t, manager.PublishOpenState(state.PLACE_SPACE, state.CLOSING))
+	require.NotNil(t, manager.PublishOpenState(state.PLACE_SPACE, state.OpenValue("moin")))
+	// legacy values are not published
+	require.NotNil(t, manager.PublishOpenState(state.PLACE_SPACE, state.OpenValue("closed")))
+	// no topic configured
+	require.NotNil(t, manager.PublishOpenState(state.PLACE_RADSTELLE, state.OPEN))
+	// no client (replay mode)
+	require.NotNil(t, manager.PublishOpenState(state.PLACE_SPACE, state.OPEN))
+}
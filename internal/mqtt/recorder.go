@@ -0,0 +1,130 @@
+package mqtt
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// A single received mqtt message, stored as one json line in the record file.
+type RecordedMessage struct {
+	Topic     string    `json:"topic"`
+	Payload   string    `json:"payload"`
+	Retained  bool      `json:"retained"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Appends every received message to a file (newline-delimited json).
+type recorder struct {
+	lock    sync.Mutex
+	file    *os.File
+	encoder *json.Encoder
+}
+
+func newRecorder(filename string) (*recorder, error) {
+	file, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &recorder{file: file, encoder: json.NewEncoder(file)}, nil
+}
+
+func (r *recorder) record(message mqtt.Message) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	entry := RecordedMessage{
+		Topic:     message.Topic(),
+		Payload:   string(message.Payload()),
+		Retained:  message.Retained(),
+		Timestamp: time.Now(),
+	}
+	if err := r.encoder.Encode(entry); err != nil {
+		mqttLogger.WithError(err).Error("Can't record message.")
+	}
+}
+
+// wraps the recorded message to be used in the normal message handlers
+type replayMessage struct {
+	recorded RecordedMessage
+}
+
+func (m *replayMessage) Duplicate() bool {
+	return false
+}
+
+func (m *replayMessage) Qos() byte {
+	return 0
+}
+
+func (m *replayMessage) Retained() bool {
+	return m.recorded.Retained
+}
+
+func (m *replayMessage) Topic() string {
+	return m.recorded.Topic
+}
+
+func (m *replayMessage) MessageID() uint16 {
+	return 0
+}
+
+func (m *replayMessage) Payload() []byte {
+	return []byte(m.recorded.Payload)
+}
+
+func (m *replayMessage) Ack() {
+}
+
+// Feeds the messages of a record file to the subscribed handlers. The original timing is kept, divided by the
+// speed factor (e.g. 10 for 10x faster). A speed of 0 replays the messages without any delay.
+// Blocks until the whole file is processed.
+func (h *MqttManager) Replay(filename string, speed float64) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	replayLogger := mqttLogger.WithField("replayFile", filename)
+	replayLogger.WithField("speed", speed).Info("Starting replay.")
+
+	scanner := bufio.NewScanner(file)
+	// the devices payload can be big
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+
+	var lastTimestamp time.Time
+	count := 0
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var recorded RecordedMessage
+		if err := json.Unmarshal(scanner.Bytes(), &recorded); err != nil {
+			replayLogger.WithError(err).Warn("Skipping invalid line.")
+			continue
+		}
+
+		if speed > 0 && !lastTimestamp.IsZero() && recorded.Timestamp.After(lastTimestamp) {
+			time.Sleep(time.Duration(float64(recorded.Timestamp.Sub(lastTimestamp)) / speed))
+		}
+		lastTimestamp = recorded.Timestamp
+
+		handler, ok := h.handlers[recorded.Topic]
+		if !ok {
+			replayLogger.WithField("topic", recorded.Topic).Debug("No handler for topic.")
+			continue
+		}
+		handler(nil, &replayMessage{recorded})
+		count++
+	}
+
+	replayLogger.WithField("messages", count).Info("Replay finished.")
+	return scanner.Err()
+}
@@ -0,0 +1,50 @@
+package mqtt
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ktt-ol/status2/internal/conf"
+	"github.com/ktt-ol/status2/internal/events"
+	"github.com/ktt-ol/status2/internal/state"
+	"github.com/ktt-ol/status2/internal/test"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_recordAndReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "status2-mqtt")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	recordFile := filepath.Join(dir, "record.jsonl")
+
+	rec, err := newRecorder(recordFile)
+	require.Nil(t, err)
+	rec.record(&test.MessageMock{TopicData: "/radstelle", PayloadData: []byte("open"), RetainedData: true})
+	rec.record(&test.MessageMock{TopicData: "/unknown", PayloadData: []byte("foo")})
+	rec.record(&test.MessageMock{TopicData: "/power/front", PayloadData: []byte("1000")})
+	rec.record(&test.MessageMock{TopicData: "/radstelle", PayloadData: []byte("none")})
+
+	content, err := ioutil.ReadFile(recordFile)
+	require.Nil(t, err)
+	require.Contains(t, string(content), `{"topic":"/radstelle","payload":"open","retained":true,"timestamp":"`)
+
+	eventsMock := new(test.EventManagerMock)
+	appState := state.NewDefaultState()
+	mqttConf := conf.MqttConf{Topics: conf.MqttTopicsConf{StateRadstelle: "/radstelle", EnergyFront: "/power/front"}}
+	manager := NewReplayMqttManager(mqttConf, conf.KeyholderConf{}, eventsMock, appState)
+	require.True(t, appState.Mqtt.Connected)
+	eventsMock.EmitCount = 0
+
+	require.Nil(t, manager.Replay(recordFile, 0))
+	require.Equal(t, 3, eventsMock.EmitCount)
+	require.Equal(t, events.TOPIC_RADSTELLE_OPEN_STATE, eventsMock.LastEvent)
+	require.Equal(t, state.NONE, appState.Open.Radstelle.Value)
+	require.Equal(t, 1.0, appState.PowerUsage.Front.Value)
+
+	// publishing is not possible without a broker
+	require.False(t, manager.publish("/radstelle", "open"))
+
+	require.NotNil(t, manager.Replay(filepath.Join(dir, "missing.jsonl"), 0))
+}
@@ -15,16 +15,25 @@ type MqttState struct {
 type OpenValueTs struct {
 	Value     OpenValue `json:"state"`
 	Timestamp int64     `json:"timestamp"`
+	// true if the value was restored from a retained mqtt message or the db, i.e. it's not a new change
+	Restored bool `json:"restored"`
+}
+
+type KeyholderState struct {
+	Id   string
+	Name string
+	// the name shown to the public, empty if the keyholder is hidden
+	Public string
 }
 
 type OpenState struct {
-	Keyholder            string
+	Keyholder            *KeyholderState
 	Space                *OpenValueTs
 	Radstelle            *OpenValueTs
 	Lab3d                *OpenValueTs
-	KeyholderMachining   string
+	KeyholderMachining   *KeyholderState
 	Machining            *OpenValueTs
-	KeyholderWoodworking string
+	KeyholderWoodworking *KeyholderState
 	Woodworking          *OpenValueTs
 }
 
@@ -51,8 +60,9 @@ type SpaceDevicesState struct {
 }
 
 type PowerValueTs struct {
-	Value     float64 `json:"value"`
-	Timestamp int64   `json:"timestamp"`
+	Value     float64   `json:"value"`
+	Unit      PowerUnit `json:"unit"`
+	Timestamp int64     `json:"timestamp"`
 }
 
 type PowerUsageState struct {
@@ -82,11 +92,14 @@ func NewDefaultState() *State {
 			SpaceBrokerOnline: false,
 		},
 		Open: &OpenState{
-			Space:       &OpenValueTs{Value: NONE, Timestamp: 0},
-			Radstelle:   &OpenValueTs{Value: NONE, Timestamp: 0},
-			Lab3d:       &OpenValueTs{Value: NONE, Timestamp: 0},
-			Machining:   &OpenValueTs{Value: NONE, Timestamp: 0},
-			Woodworking: &OpenValueTs{Value: NONE, Timestamp: 0},
+			Keyholder:            &KeyholderState{},
+			KeyholderMachining:   &KeyholderState{},
+			KeyholderWoodworking: &KeyholderState{},
+			Space:                &OpenValueTs{Value: NONE, Timestamp: 0},
+			Radstelle:            &OpenValueTs{Value: NONE, Timestamp: 0},
+			Lab3d:                &OpenValueTs{Value: NONE, Timestamp: 0},
+			Machining:            &OpenValueTs{Value: NONE, Timestamp: 0},
+			Woodworking:          &OpenValueTs{Value: NONE, Timestamp: 0},
 		},
 		SpaceDevices: &SpaceDevicesState{
 			PeopleAndDevices: structs.PeopleAndDevices{
@@ -98,9 +111,9 @@ func NewDefaultState() *State {
 			Timestamp: 0,
 		},
 		PowerUsage: &PowerUsageState{
-			Front:     &PowerValueTs{Value: 0.0, Timestamp: 0},
-			Back:      &PowerValueTs{Value: 0.0, Timestamp: 0},
-			Machining: &PowerValueTs{Value: 0.0, Timestamp: 0},
+			Front:     &PowerValueTs{Value: 0.0, Unit: DEFAULT_POWER_UNIT, Timestamp: 0},
+			Back:      &PowerValueTs{Value: 0.0, Unit: DEFAULT_POWER_UNIT, Timestamp: 0},
+			Machining: &PowerValueTs{Value: 0.0, Unit: DEFAULT_POWER_UNIT, Timestamp: 0},
 		},
 		Freifunk: &FreifunkState{
 			ClientCount: 0,
@@ -0,0 +1,59 @@
+package state
+
+import (
+	"errors"
+
+	"github.com/ktt-ol/status2/internal/events"
+)
+
+type Place string
+
+func (p Place) StrValue() string {
+	return string(p)
+}
+
+const (
+	PLACE_SPACE       Place = "space"
+	PLACE_RADSTELLE   Place = "radstelle"
+	PLACE_LAB3D       Place = "lab3d"
+	PLACE_MACHINING   Place = "machining"
+	PLACE_WOODWORKING Place = "woodworking"
+)
+
+var AllPlaces = [...]Place{PLACE_SPACE, PLACE_RADSTELLE, PLACE_LAB3D, PLACE_MACHINING, PLACE_WOODWORKING}
+
+func ParsePlace(value string) (Place, error) {
+	for _, place := range AllPlaces {
+		if place.StrValue() == value {
+			return place, nil
+		}
+	}
+
+	return Place(value), errors.New("Invalid place: " + value)
+}
+
+// the event that is emitted for open state changes of this place
+func (p Place) OpenStateEvent() (events.EventName, error) {
+	switch p {
+	case PLACE_SPACE:
+		return events.TOPIC_SPACE_OPEN_STATE, nil
+	case PLACE_RADSTELLE:
+		return events.TOPIC_RADSTELLE_OPEN_STATE, nil
+	case PLACE_LAB3D:
+		return events.TOPIC_LAB_3D_OPEN_STATE, nil
+	case PLACE_MACHINING:
+		return events.TOPIC_MACHINING_OPEN_STATE, nil
+	case PLACE_WOODWORKING:
+		return events.TOPIC_WOODWORKING_OPEN_STATE, nil
+	default:
+		return "", errors.New("Unknown place: " + p.StrValue())
+	}
+}
+
+func (os *OpenState) ForPlace(place Place) (*OpenValueTs, error) {
+	event, err := place.OpenStateEvent()
+	if err != nil {
+		return nil, err
+	}
+	return os.OpenStateForEvent(event)
+}
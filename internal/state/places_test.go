@@ -0,0 +1,39 @@
+package state
+
+import (
+	"testing"
+
+	"github.com/ktt-ol/status2/internal/events"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ParsePlace(t *testing.T) {
+	for _, place := range AllPlaces {
+		parsed, err := ParsePlace(place.StrValue())
+		require.Nil(t, err)
+		require.Equal(t, place, parsed)
+
+		// every place has an event
+		_, err = place.OpenStateEvent()
+		require.Nil(t, err)
+	}
+
+	_, err := ParsePlace("")
+	require.NotNil(t, err)
+	_, err = ParsePlace("Space")
+	require.NotNil(t, err)
+}
+
+func Test_ForPlace(t *testing.T) {
+	st := NewDefaultState()
+
+	openState, err := st.Open.ForPlace(PLACE_WOODWORKING)
+	require.Nil(t, err)
+	require.True(t, st.Open.Woodworking == openState)
+
+	event, _ := PLACE_LAB3D.OpenStateEvent()
+	require.Equal(t, events.TOPIC_LAB_3D_OPEN_STATE, event)
+
+	_, err = st.Open.ForPlace(Place("kitchen"))
+	require.NotNil(t, err)
+}
@@ -0,0 +1,81 @@
+package state
+
+import "errors"
+
+type PowerUnit string
+
+const (
+	MILLIWATT PowerUnit = "mW"
+	WATT      PowerUnit = "W"
+	KILOWATT  PowerUnit = "kW"
+)
+
+// all power values in the state use this unit
+const DEFAULT_POWER_UNIT = WATT
+
+func ParsePowerUnit(value string) (PowerUnit, error) {
+	unit := PowerUnit(value)
+	switch unit {
+	case MILLIWATT:
+		fallthrough
+	case WATT:
+		fallthrough
+	case KILOWATT:
+		return unit, nil
+	}
+
+	return unit, errors.New("Invalid power unit: " + value)
+}
+
+// the factor to convert a value of the unit to watt
+func (u PowerUnit) toWatt() float64 {
+	switch u {
+	case MILLIWATT:
+		return 0.001
+	case KILOWATT:
+		return 1000
+	default:
+		return 1
+	}
+}
+
+// Converts the value from one unit to another, e.g. 1500 W -> 1.5 kW
+func ConvertPower(value float64, from PowerUnit, to PowerUnit) float64 {
+	if from == to {
+		return value
+	}
+	return value * from.toWatt() / to.toWatt()
+}
+
+type PowerMeter string
+
+const (
+	POWER_METER_FRONT     PowerMeter = "front"
+	POWER_METER_BACK      PowerMeter = "back"
+	POWER_METER_MACHINING PowerMeter = "machining"
+)
+
+var AllPowerMeters = [...]PowerMeter{POWER_METER_FRONT, POWER_METER_BACK, POWER_METER_MACHINING}
+
+func ParsePowerMeter(value string) (PowerMeter, error) {
+	for _, meter := range AllPowerMeters {
+		if string(meter) == value {
+			return meter, nil
+		}
+	}
+
+	return PowerMeter(value), errors.New("Invalid power meter: " + value)
+}
+
+func (s *PowerUsageState) ForMeter(meter PowerMeter) (*PowerValueTs, error) {
+	switch meter {
+	case POWER_METER_FRONT:
+		return s.Front, nil
+	case POWER_METER_BACK:
+		return s.Back, nil
+	case POWER_METER_MACHINING:
+		return s.Machining, nil
+	}
+
+	return nil, errors.New("Invalid power meter: " + string(meter))
+}
@@ -0,0 +1,51 @@
+package state
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ParsePowerUnit(t *testing.T) {
+	for _, unit := range []string{"mW", "W", "kW"} {
+		parsed, err := ParsePowerUnit(unit)
+		require.Nil(t, err)
+		require.Equal(t, PowerUnit(unit), parsed)
+	}
+
+	_, err := ParsePowerUnit("")
+	require.NotNil(t, err)
+	_, err = ParsePowerUnit("w")
+	require.NotNil(t, err)
+	_, err = ParsePowerUnit("kWh")
+	require.NotNil(t, err)
+}
+
+func Test_ConvertPower(t *testing.T) {
+	require.Equal(t, 1.5, ConvertPower(1.5, WATT, WATT))
+	require.Equal(t, 1.5, ConvertPower(1500, WATT, KILOWATT))
+	require.Equal(t, 1500.0, ConvertPower(1.5, KILOWATT, WATT))
+	require.Equal(t, 123.4, ConvertPower(123400, MILLIWATT, WATT))
+	require.InDelta(t, 123400.0, ConvertPower(123.4, WATT, MILLIWATT), 0.0001)
+	require.Equal(t, 2.0, ConvertPower(2000000, MILLIWATT, KILOWATT))
+}
+
+func Test_PowerMeter(t *testing.T) {
+	st := NewDefaultState()
+	for _, meter := range AllPowerMeters {
+		parsed, err := ParsePowerMeter(string(meter))
+		require.Nil(t, err)
+		require.Equal(t, meter, parsed)
+
+		value, err := st.PowerUsage.ForMeter(meter)
+		require.Nil(t, err)
+		require.NotNil(t, value)
+	}
+	back, _ := st.PowerUsage.ForMeter(POWER_METER_BACK)
+	require.True(t, st.PowerUsage.Back == back)
+
+	_, err := ParsePowerMeter("kitchen")
+	require.NotNil(t, err)
+	_, err = st.PowerUsage.ForMeter("kitchen")
+	require.NotNil(t, err)
+}
@@ -0,0 +1,51 @@
+package systemd
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	NOTIFY_READY    = "READY=1"
+	NOTIFY_WATCHDOG = "WATCHDOG=1"
+)
+
+// Sends the state to the systemd notify socket (sd_notify). Returns false without an error if not started by systemd
+// with Type=notify or a WatchdogSec.
+func Notify(state string) (bool, error) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return false, nil
+	}
+	if socket[0] == '@' {
+		// abstract namespace
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// The interval the watchdog must be notified in, 0 if the watchdog is disabled.
+func WatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		// meant for another process
+		return 0
+	}
+
+	return time.Duration(usec) * time.Microsecond
+}
@@ -0,0 +1,56 @@
+package systemd
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Notify(t *testing.T) {
+	os.Unsetenv("NOTIFY_SOCKET")
+	sent, err := Notify(NOTIFY_READY)
+	require.Nil(t, err)
+	require.False(t, sent)
+
+	dir, err := ioutil.TempDir("", "systemd")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	require.Nil(t, err)
+	defer conn.Close()
+
+	os.Setenv("NOTIFY_SOCKET", socket)
+	defer os.Unsetenv("NOTIFY_SOCKET")
+	sent, err = Notify(NOTIFY_WATCHDOG)
+	require.Nil(t, err)
+	require.True(t, sent)
+
+	buffer := make([]byte, 64)
+	n, err := conn.Read(buffer)
+	require.Nil(t, err)
+	require.Equal(t, NOTIFY_WATCHDOG, string(buffer[:n]))
+}
+
+func Test_WatchdogInterval(t *testing.T) {
+	defer os.Unsetenv("WATCHDOG_USEC")
+	defer os.Unsetenv("WATCHDOG_PID")
+
+	os.Unsetenv("WATCHDOG_USEC")
+	require.Equal(t, time.Duration(0), WatchdogInterval())
+
+	os.Setenv("WATCHDOG_USEC", "30000000")
+	require.Equal(t, 30*time.Second, WatchdogInterval())
+
+	os.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+	require.Equal(t, 30*time.Second, WatchdogInterval())
+
+	os.Setenv("WATCHDOG_PID", "1")
+	require.Equal(t, time.Duration(0), WatchdogInterval())
+}
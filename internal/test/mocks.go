@@ -1,8 +1,15 @@
 package test
 
 type MessageMock struct {
-	PayloadData []byte
-	TopicData   string
+	PayloadData  []byte
+	TopicData    string
+	RetainedData bool
+}
+
+// sets the payload and returns the mock, handy for calling a handler directly
+func (m *MessageMock) WithPayload(payload string) *MessageMock {
+	m.PayloadData = []byte(payload)
+	return m
 }
 
 func (*MessageMock) Duplicate() bool {
@@ -13,8 +20,8 @@ func (*MessageMock) Qos() byte {
 	panic("implement me")
 }
 
-func (*MessageMock) Retained() bool {
-	panic("implement me")
+func (m *MessageMock) Retained() bool {
+	return m.RetainedData
 }
 
 func (m *MessageMock) Topic() string {
@@ -4,6 +4,7 @@ import (
 	"github.com/sirupsen/logrus"
 	"github.com/ktt-ol/status2/internal/conf"
 	"github.com/ktt-ol/status2/internal/events"
+	"github.com/ktt-ol/status2/internal/metrics"
 	"github.com/ktt-ol/status2/internal/state"
 	"github.com/bep/debounce"
 	"time"
@@ -15,6 +16,9 @@ var logger = logrus.WithField("where", "twitter")
 const TWEET_TEMPLATE_OPEN = "%s ist seit %s Uhr geöffnet, kommt vorbei! Details unter https://status.mainframe.io/"
 const TWEET_TEMPLATE_CLOSED = "%s ist leider seit %s Uhr geschlossen. Details unter https://status.mainframe.io/"
 
+// the channel label of the notification metrics
+const NOTIFICATION_CHANNEL = "twitter"
+
 func getPlaceName(event events.EventName) string {
 	switch event {
 	case events.TOPIC_SPACE_OPEN_STATE:
@@ -78,6 +82,15 @@ func (t *TwitterHandler) onOpenStateChange(topic events.EventName) {
 }
 
 func (t *TwitterHandler) updateStateAndTweetDebounced(topic events.EventName, openValueTs *state.OpenValueTs) {
+	if openValueTs.Restored {
+		// e.g. a retained mqtt value after a reconnect, this is no news for the public
+		logger.WithField("topic", topic).Debug("Restored state, no tweet.")
+		if _, ok := t.lastStateSend[topic]; !ok {
+			t.lastStateSend[topic] = *openValueTs
+		}
+		return
+	}
+
 	makeMsgAndSend := func() {
 		// get last state
 		lastState, ok := t.lastStateSend[topic]
@@ -107,8 +120,11 @@ func (t *TwitterHandler) updateStateAndTweetDebounced(topic events.EventName, op
 		logger.WithField("msg", msg).Debug("Sending tweet.")
 		err := t.api.Send(msg)
 		if err != nil {
+			metrics.NotificationsFailed.Inc(NOTIFICATION_CHANNEL)
 			logger.WithError(err).Error("Error sending tweet")
+			return
 		}
+		metrics.NotificationsSent.Inc(NOTIFICATION_CHANNEL)
 	}
 
 	_, ok := t.lastStateSend[topic]
@@ -160,3 +160,22 @@ func Test_skipFirstStatus(t *testing.T) {
 	require.Equal(t, 1, mockImpl.tweetCount)
 }
 
+
+func Test_noTweetForRestoredStatus(t *testing.T) {
+	appState, twitt, mockImpl := setupObjects(t, 0)
+
+	appState.Open.Space.Value = state.NONE
+	twitt.onOpenStateChange(events.TOPIC_SPACE_OPEN_STATE)
+
+	// e.g. a reconnect, the broker sends a retained state we haven't seen
+	appState.Open.Space.Value = state.OPEN
+	appState.Open.Space.Restored = true
+	twitt.onOpenStateChange(events.TOPIC_SPACE_OPEN_STATE)
+	require.Equal(t, 0, mockImpl.tweetCount)
+
+	// the next real change is compared with the last tweeted state
+	appState.Open.Space.Value = state.OPEN_PLUS
+	appState.Open.Space.Restored = false
+	twitt.onOpenStateChange(events.TOPIC_SPACE_OPEN_STATE)
+	require.Equal(t, 1, mockImpl.tweetCount)
+}
@@ -0,0 +1,85 @@
+package web
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ktt-ol/status2/internal/state"
+	"github.com/sirupsen/logrus"
+)
+
+var auditLogger = logrus.WithField("where", "audit")
+
+const (
+	AUDIT_SOURCE_API    = "api"
+	AUDIT_SOURCE_SWITCH = "switch"
+	// the until time of a state set via the api is over
+	AUDIT_SOURCE_UNTIL = "until"
+)
+
+// Who changed which state.
+type auditEntry struct {
+	Time     time.Time       `json:"time"`
+	User     string          `json:"user"`
+	Source   string          `json:"source"`
+	Place    state.Place     `json:"place"`
+	Previous state.OpenValue `json:"previous"`
+	State    state.OpenValue `json:"state"`
+	Message  string          `json:"message,omitempty"`
+	Until    *time.Time      `json:"until,omitempty"`
+	RemoteIp string          `json:"remoteIp,omitempty"`
+	// set if the change failed
+	Error string `json:"error,omitempty"`
+}
+
+// Every entry is logged and, if a file is configured, appended as json line to the file.
+type auditLog struct {
+	lock     sync.Mutex
+	filename string
+}
+
+func newAuditLog(filename string) *auditLog {
+	return &auditLog{filename: filename}
+}
+
+func (a *auditLog) record(entry auditEntry) {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+
+	fields := logrus.Fields{"user": entry.User, "source": entry.Source, "place": entry.Place,
+		"previous": entry.Previous, "state": entry.State}
+	if entry.Error != "" {
+		auditLogger.WithFields(fields).WithField("error", entry.Error).Warn("State change failed.")
+	} else {
+		auditLogger.WithFields(fields).Info("State changed.")
+	}
+
+	if a.filename == "" {
+		return
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if err := appendAuditFile(a.filename, entry); err != nil {
+		auditLogger.WithError(err).Error("Can't write to the audit log file.")
+	}
+}
+
+func appendAuditFile(filename string, entry auditEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
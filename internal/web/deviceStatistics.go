@@ -0,0 +1,174 @@
+package web
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ktt-ol/status2/internal/db"
+	"github.com/sirupsen/logrus"
+)
+
+var deviceStatsLogger = logrus.WithField("where", "DeviceStatistics")
+
+const maxDeviceStatsRange = 366 * 24 * time.Hour
+const maxRawDeviceStatsRange = 31 * 24 * time.Hour
+
+// The average people and devices while the space was open or closed.
+type occupancy struct {
+	Hours      float64 `json:"hours"`
+	AvgPeople  float64 `json:"avgPeople"`
+	AvgDevices float64 `json:"avgDevices"`
+}
+
+type deviceAnalytics struct {
+	// the average people per weekday (0 = sunday) and hour of the day
+	Heatmap [7][24]float64 `json:"heatmap"`
+	Open    occupancy      `json:"open"`
+	Closed  occupancy      `json:"closed"`
+}
+
+// Returns the people and devices over time, an occupancy heatmap and the occupancy while open and closed.
+// Params: from, to (default: the last 7 days), resolution (raw, hour (default), day), tz (IANA time zone, default:
+// local, only for the heatmap)
+func DeviceStatistics(dbMgr db.DbManager, group *gin.RouterGroup) {
+	group.GET("", func(c *gin.Context) {
+		location, err := parseLocationParam(c.Query("tz"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		to, err := parseTimeParam(c.Query("to"), time.Now(), location)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		from, err := parseTimeParam(c.Query("from"), to.AddDate(0, 0, -7), location)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		resolution, err := db.ParseResolution(c.DefaultQuery("resolution", string(db.RESOLUTION_HOUR)))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		maxRange := maxDeviceStatsRange
+		if resolution == db.RESOLUTION_RAW {
+			maxRange = maxRawDeviceStatsRange
+		}
+		if !from.Before(to) || to.Sub(from) > maxRange {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid range, from must be before to and the maximum range is " +
+				"366 days (31 days for raw)."})
+			return
+		}
+
+		hourly, err := dbMgr.GetDevicesStats(from, to, db.RESOLUTION_HOUR)
+		if err != nil {
+			deviceStatsLogger.WithError(err).Warn("Can't read the devices.")
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "db not available"})
+			return
+		}
+		values := hourly
+		if resolution != db.RESOLUTION_HOUR {
+			if values, err = dbMgr.GetDevicesStats(from, to, resolution); err != nil {
+				deviceStatsLogger.WithError(err).Warn("Can't read the devices.")
+				c.JSON(http.StatusServiceUnavailable, gin.H{"error": "db not available"})
+				return
+			}
+		}
+
+		openStates, err := dbMgr.GetOpenStates(db.PLACE_SPACE, from, to)
+		if err != nil {
+			deviceStatsLogger.WithError(err).Warn("Can't read the open states.")
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "db not available"})
+			return
+		}
+		openEntries := clipEntries(normalizeResults(openStates), from, to, location)
+
+		analytics := analyzeDevices(hourly, openEntries, from, to, time.Now(), location)
+		c.JSON(http.StatusOK, gin.H{
+			"resolution": resolution,
+			"from":       from,
+			"to":         to,
+			"values":     values,
+			"heatmap":    analytics.Heatmap,
+			"open":       analytics.Open,
+			"closed":     analytics.Closed,
+		})
+	})
+}
+
+// The stats must be hourly, every hour is split into the open and closed part by the (clipped) open entries. Only
+// the part of an hour in [from, to) and before now counts. The hours are the db buckets, not the hours of the location.
+func analyzeDevices(hourly []db.DevicesStat, openEntries []*entry, from time.Time, to time.Time, now time.Time,
+	location *time.Location) deviceAnalytics {
+	openSeconds := make(map[int64]float64)
+	for _, e := range openEntries {
+		for begin := *e.begin; begin.Before(*e.end); {
+			start, end := db.HourBucket(begin)
+			if e.end.Before(end) {
+				end = *e.end
+			}
+			openSeconds[start.Unix()] += end.Sub(begin).Seconds()
+			begin = end
+		}
+	}
+	if now.Before(to) {
+		to = now
+	}
+
+	var result deviceAnalytics
+	var peopleSum [7][24]float64
+	var count [7][24]int
+	var open, closed struct{ seconds, people, devices float64 }
+	for _, stat := range hourly {
+		ts := stat.Timestamp.In(location)
+		peopleSum[ts.Weekday()][ts.Hour()] += stat.AvgPeople
+		count[ts.Weekday()][ts.Hour()]++
+
+		start, end := db.HourBucket(stat.Timestamp)
+		if start.Before(from) {
+			start = from
+		}
+		if to.Before(end) {
+			end = to
+		}
+		covered := end.Sub(start).Seconds()
+		if covered <= 0 {
+			continue
+		}
+		openPart := openSeconds[stat.Timestamp.Unix()]
+		if openPart > covered {
+			openPart = covered
+		}
+		closedPart := covered - openPart
+		open.seconds += openPart
+		open.people += stat.AvgPeople * openPart
+		open.devices += stat.AvgDevices * openPart
+		closed.seconds += closedPart
+		closed.people += stat.AvgPeople * closedPart
+		closed.devices += stat.AvgDevices * closedPart
+	}
+
+	for weekday := range count {
+		for hour := range count[weekday] {
+			if count[weekday][hour] > 0 {
+				result.Heatmap[weekday][hour] = peopleSum[weekday][hour] / float64(count[weekday][hour])
+			}
+		}
+	}
+
+	result.Open.Hours = open.seconds / 3600
+	if open.seconds > 0 {
+		result.Open.AvgPeople = open.people / open.seconds
+		result.Open.AvgDevices = open.devices / open.seconds
+	}
+	result.Closed.Hours = closed.seconds / 3600
+	if closed.seconds > 0 {
+		result.Closed.AvgPeople = closed.people / closed.seconds
+		result.Closed.AvgDevices = closed.devices / closed.seconds
+	}
+
+	return result
+}
@@ -0,0 +1,73 @@
+package web
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ktt-ol/status2/internal/db"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_analyzeDevices(t *testing.T) {
+	// monday
+	start := *mkTestTime(2018, 1, 1, 18, 0)
+	hourly := []db.DevicesStat{
+		{Timestamp: start, AvgPeople: 2, AvgDevices: 4},
+		{Timestamp: start.Add(time.Hour), AvgPeople: 4, AvgDevices: 8},
+		{Timestamp: start.Add(2 * time.Hour), AvgPeople: 0, AvgDevices: 1},
+		// the next monday
+		{Timestamp: start.AddDate(0, 0, 7), AvgPeople: 6, AvgDevices: 6},
+	}
+	// open from 18:30 to 20:00
+	openEntries := []*entry{{mkTestTime(2018, 1, 1, 18, 30), mkTestTime(2018, 1, 1, 20, 0)}}
+
+	result := analyzeDevices(hourly, openEntries, start, start.AddDate(0, 0, 8), start.AddDate(0, 0, 9), time.UTC)
+
+	require.Equal(t, 4.0, result.Heatmap[time.Monday][18])
+	require.Equal(t, 4.0, result.Heatmap[time.Monday][19])
+	require.Equal(t, 0.0, result.Heatmap[time.Tuesday][18])
+
+	require.Equal(t, 1.5, result.Open.Hours)
+	require.InDelta(t, (2*0.5+4*1)/1.5, result.Open.AvgPeople, 0.0001)
+	require.InDelta(t, (4*0.5+8*1)/1.5, result.Open.AvgDevices, 0.0001)
+	require.Equal(t, 2.5, result.Closed.Hours)
+	require.InDelta(t, (2*0.5+0+6)/2.5, result.Closed.AvgPeople, 0.0001)
+}
+
+// the first hour starts after from and the current hour is not over yet
+func Test_analyzeDevices_partialHours(t *testing.T) {
+	start := *mkTestTime(2018, 1, 1, 18, 0)
+	hourly := []db.DevicesStat{
+		{Timestamp: start, AvgPeople: 2, AvgDevices: 4},
+		{Timestamp: start.Add(time.Hour), AvgPeople: 4, AvgDevices: 8},
+		{Timestamp: start.Add(2 * time.Hour), AvgPeople: 0, AvgDevices: 1},
+	}
+
+	result := analyzeDevices(hourly, nil, start.Add(30*time.Minute), start.AddDate(0, 0, 1),
+		start.Add(150*time.Minute), time.UTC)
+	require.Equal(t, 0.0, result.Open.Hours)
+	require.Equal(t, 2.0, result.Closed.Hours)
+	require.InDelta(t, (2*0.5+4*1)/2.0, result.Closed.AvgPeople, 0.0001)
+}
+
+// the location has a half hour offset, the stats are still keyed by the db hours
+func Test_analyzeDevices_halfHourOffset(t *testing.T) {
+	kolkata, err := time.LoadLocation("Asia/Kolkata")
+	require.Nil(t, err)
+	local := time.Local
+	time.Local = time.UTC
+	defer func() { time.Local = local }()
+
+	start := *mkTestTime(2018, 1, 1, 18, 0)
+	hourly := []db.DevicesStat{
+		{Timestamp: start, AvgPeople: 2, AvgDevices: 4},
+		{Timestamp: start.Add(time.Hour), AvgPeople: 4, AvgDevices: 8},
+	}
+	begin, end := start.In(kolkata), start.Add(2*time.Hour).In(kolkata)
+	openEntries := []*entry{{&begin, &end}}
+
+	result := analyzeDevices(hourly, openEntries, start, start.AddDate(0, 0, 1), start.AddDate(0, 0, 2), kolkata)
+	require.Equal(t, 2.0, result.Open.Hours)
+	require.InDelta(t, 3.0, result.Open.AvgPeople, 0.0001)
+	require.Equal(t, 0.0, result.Closed.Hours)
+}
@@ -0,0 +1,226 @@
+package web
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ktt-ol/status2/internal/conf"
+	"github.com/ktt-ol/status2/internal/db"
+	"github.com/ktt-ol/status2/internal/state"
+	"github.com/sirupsen/logrus"
+)
+
+var energyLogger = logrus.WithField("where", "Energy")
+
+const (
+	RESOLUTION_HOUR  = "hour"
+	RESOLUTION_DAY   = "day"
+	RESOLUTION_MONTH = "month"
+)
+
+const maxEnergyRange = 366 * 24 * time.Hour
+
+// The energy of a meter for one period (hour, day or month), split by the space open state.
+type energyTotal struct {
+	Meter      state.PowerMeter `json:"meter"`
+	Start      time.Time        `json:"start"`
+	OpenKWh    float64          `json:"openKWh"`
+	ClosedKWh  float64          `json:"closedKWh"`
+	TotalKWh   float64          `json:"totalKWh"`
+	OpenCost   float64          `json:"openCost"`
+	ClosedCost float64          `json:"closedCost"`
+	TotalCost  float64          `json:"totalCost"`
+}
+
+// Returns the energy totals per meter and period from the stored power samples, as json or csv (format=csv).
+// Params: from, to (default: the last 30 days), meter (default: all), resolution (hour, day (default), month)
+func Energy(config conf.EnergyConf, dbMgr db.DbManager, group *gin.RouterGroup) {
+	group.GET("", func(c *gin.Context) {
+		now := time.Now()
+		to, err := parseTimeParam(c.Query("to"), now, time.Local)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		from, err := parseTimeParam(c.Query("from"), to.AddDate(0, 0, -30), time.Local)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if !from.Before(to) || to.Sub(from) > maxEnergyRange {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid range, from must be before to and the maximum range is 366 days."})
+			return
+		}
+
+		var meter state.PowerMeter
+		if c.Query("meter") != "" {
+			meter, err = state.ParsePowerMeter(c.Query("meter"))
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+
+		resolution := c.DefaultQuery("resolution", RESOLUTION_DAY)
+		if _, err := periodStart(now, resolution, time.Local); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		openStates, err := dbMgr.GetOpenStates(db.PLACE_SPACE, from, to)
+		if err != nil {
+			energyLogger.WithError(err).Warn("Can't read the open states.")
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "db not available"})
+			return
+		}
+
+		calc := newEnergyCalculator(normalizeResults(openStates), resolution, config.PricePerKWh, time.Local)
+		if err := dbMgr.ForEachPowerSample(meter, from, to, calc.add); err != nil {
+			energyLogger.WithError(err).Warn("Can't read the power samples.")
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "db not available"})
+			return
+		}
+		totals := calc.totals()
+
+		if c.Query("format") == "csv" {
+			c.Header("Content-Disposition", "attachment; filename=energy.csv")
+			c.Header("Content-Type", "text/csv; charset=utf-8")
+			if err := writeEnergyCsv(c.Writer, totals); err != nil {
+				energyLogger.WithError(err).Warn("Can't write the csv.")
+			}
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"resolution":  resolution,
+			"currency":    config.Currency,
+			"pricePerKWh": config.PricePerKWh,
+			"totals":      totals,
+		})
+	})
+}
+
+type energyKey struct {
+	meter state.PowerMeter
+	start int64
+}
+
+type energyCalculator struct {
+	openEntries []*entry
+	resolution  string
+	pricePerKWh float64
+	location    *time.Location
+	totalsByKey map[energyKey]*energyTotal
+}
+
+func newEnergyCalculator(openEntries []*entry, resolution string, pricePerKWh float64, location *time.Location) *energyCalculator {
+	return &energyCalculator{
+		openEntries: openEntries,
+		resolution:  resolution,
+		pricePerKWh: pricePerKWh,
+		location:    location,
+		totalsByKey: make(map[energyKey]*energyTotal),
+	}
+}
+
+// The sample is counted as open or closed by the state at the start of the sample.
+func (calc *energyCalculator) add(sample db.PowerSample) error {
+	start, err := periodStart(sample.Timestamp, calc.resolution, calc.location)
+	if err != nil {
+		return err
+	}
+
+	key := energyKey{sample.Meter, start.Unix()}
+	total, ok := calc.totalsByKey[key]
+	if !ok {
+		total = &energyTotal{Meter: sample.Meter, Start: start}
+		calc.totalsByKey[key] = total
+	}
+
+	kWh := sample.Avg * float64(sample.DurationInSec) / 3600 / 1000
+	if calc.isOpen(sample.Timestamp) {
+		total.OpenKWh += kWh
+	} else {
+		total.ClosedKWh += kWh
+	}
+
+	return nil
+}
+
+func (calc *energyCalculator) isOpen(ts time.Time) bool {
+	// the first entry that begins after ts
+	index := sort.Search(len(calc.openEntries), func(i int) bool {
+		return calc.openEntries[i].begin.After(ts)
+	})
+	if index == 0 {
+		return false
+	}
+
+	last := calc.openEntries[index-1]
+	return last.end == nil || ts.Before(*last.end)
+}
+
+// the totals with costs, ordered by start and meter
+func (calc *energyCalculator) totals() []energyTotal {
+	result := make([]energyTotal, 0, len(calc.totalsByKey))
+	for _, total := range calc.totalsByKey {
+		total.TotalKWh = total.OpenKWh + total.ClosedKWh
+		total.OpenCost = total.OpenKWh * calc.pricePerKWh
+		total.ClosedCost = total.ClosedKWh * calc.pricePerKWh
+		total.TotalCost = total.TotalKWh * calc.pricePerKWh
+		result = append(result, *total)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Start.Equal(result[j].Start) {
+			return result[i].Meter < result[j].Meter
+		}
+		return result[i].Start.Before(result[j].Start)
+	})
+	return result
+}
+
+// the start of the hour, day or month in the given location
+func periodStart(ts time.Time, resolution string, location *time.Location) (time.Time, error) {
+	ts = ts.In(location)
+	switch resolution {
+	case RESOLUTION_HOUR:
+		return time.Date(ts.Year(), ts.Month(), ts.Day(), ts.Hour(), 0, 0, 0, location), nil
+	case RESOLUTION_DAY:
+		return time.Date(ts.Year(), ts.Month(), ts.Day(), 0, 0, 0, 0, location), nil
+	case RESOLUTION_MONTH:
+		return time.Date(ts.Year(), ts.Month(), 1, 0, 0, 0, 0, location), nil
+	}
+
+	return time.Time{}, errors.New("Invalid resolution: " + resolution)
+}
+
+func writeEnergyCsv(writer io.Writer, totals []energyTotal) error {
+	csvWriter := csv.NewWriter(writer)
+	csvWriter.Write([]string{"meter", "start", "open_kwh", "closed_kwh", "total_kwh", "open_cost", "closed_cost", "total_cost"})
+	for _, total := range totals {
+		csvWriter.Write([]string{
+			string(total.Meter),
+			total.Start.Format(time.RFC3339),
+			formatCsvFloat(total.OpenKWh),
+			formatCsvFloat(total.ClosedKWh),
+			formatCsvFloat(total.TotalKWh),
+			formatCsvFloat(total.OpenCost),
+			formatCsvFloat(total.ClosedCost),
+			formatCsvFloat(total.TotalCost),
+		})
+	}
+	csvWriter.Flush()
+
+	return csvWriter.Error()
+}
+
+func formatCsvFloat(value float64) string {
+	return strconv.FormatFloat(value, 'f', 4, 64)
+}
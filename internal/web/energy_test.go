@@ -0,0 +1,70 @@
+package web
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/ktt-ol/status2/internal/db"
+	"github.com/ktt-ol/status2/internal/state"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_energyCalculator(t *testing.T) {
+	loc := time.UTC
+	openStates := []db.OpenState{
+		{Value: state.OPEN, Time: time.Date(2020, 1, 1, 10, 30, 0, 0, loc)},
+		{Value: state.NONE, Time: time.Date(2020, 1, 1, 12, 0, 0, 0, loc)},
+		{Value: state.OPEN_PLUS, Time: time.Date(2020, 1, 2, 18, 0, 0, 0, loc)},
+	}
+	calc := newEnergyCalculator(normalizeResults(openStates), RESOLUTION_DAY, 0.5, loc)
+
+	sample := func(ts time.Time, watt float64) db.PowerSample {
+		return db.PowerSample{Meter: state.POWER_METER_FRONT, Timestamp: ts, Avg: watt, DurationInSec: 3600}
+	}
+	// closed
+	require.Nil(t, calc.add(sample(time.Date(2020, 1, 1, 9, 0, 0, 0, loc), 1000)))
+	// open
+	require.Nil(t, calc.add(sample(time.Date(2020, 1, 1, 11, 0, 0, 0, loc), 2000)))
+	// closed again, exactly at the closing time
+	require.Nil(t, calc.add(sample(time.Date(2020, 1, 1, 12, 0, 0, 0, loc), 500)))
+	// next day, still open (no end)
+	require.Nil(t, calc.add(sample(time.Date(2020, 1, 2, 20, 0, 0, 0, loc), 3000)))
+	backSample := sample(time.Date(2020, 1, 2, 8, 0, 0, 0, loc), 100)
+	backSample.Meter = state.POWER_METER_BACK
+	backSample.DurationInSec = 60 * 30
+	require.Nil(t, calc.add(backSample))
+
+	totals := calc.totals()
+	require.Len(t, totals, 3)
+	require.Equal(t, energyTotal{Meter: state.POWER_METER_FRONT, Start: time.Date(2020, 1, 1, 0, 0, 0, 0, loc),
+		OpenKWh: 2, ClosedKWh: 1.5, TotalKWh: 3.5, OpenCost: 1, ClosedCost: 0.75, TotalCost: 1.75}, totals[0])
+	require.Equal(t, state.POWER_METER_BACK, totals[1].Meter)
+	require.Equal(t, 0.05, totals[1].ClosedKWh)
+	require.Equal(t, 3.0, totals[2].OpenKWh)
+
+	var buffer bytes.Buffer
+	require.Nil(t, writeEnergyCsv(&buffer, totals[:1]))
+	require.Equal(t, "meter,start,open_kwh,closed_kwh,total_kwh,open_cost,closed_cost,total_cost\n"+
+		"front,2020-01-01T00:00:00Z,2.0000,1.5000,3.5000,1.0000,0.7500,1.7500\n", buffer.String())
+}
+
+func Test_periodStart(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	require.Nil(t, err)
+	// 2020-03-29 01:30 UTC is 03:30 summer time in Berlin
+	ts := time.Date(2020, 3, 29, 1, 30, 0, 0, time.UTC)
+
+	start, err := periodStart(ts, RESOLUTION_HOUR, berlin)
+	require.Nil(t, err)
+	require.Equal(t, time.Date(2020, 3, 29, 3, 0, 0, 0, berlin), start)
+	start, err = periodStart(ts, RESOLUTION_DAY, berlin)
+	require.Nil(t, err)
+	require.Equal(t, time.Date(2020, 3, 29, 0, 0, 0, 0, berlin), start)
+	start, err = periodStart(ts, RESOLUTION_MONTH, berlin)
+	require.Nil(t, err)
+	require.Equal(t, time.Date(2020, 3, 1, 0, 0, 0, 0, berlin), start)
+
+	_, err = periodStart(ts, "week", berlin)
+	require.NotNil(t, err)
+}
@@ -0,0 +1,227 @@
+package web
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ktt-ol/status2/internal/conf"
+	"github.com/ktt-ol/status2/internal/db"
+	"github.com/ktt-ol/status2/internal/state"
+	"github.com/ktt-ol/status2/internal/systemd"
+	"github.com/sirupsen/logrus"
+)
+
+var healthLogger = logrus.WithField("where", "Health")
+
+const (
+	HEALTH_OK          = "ok"
+	HEALTH_DB_DEGRADED = "db degraded"
+	HEALTH_DEGRADED    = "degraded"
+	HEALTH_FAILED      = "failed"
+	HEALTH_NOT_READY   = "not ready"
+
+	DEFAULT_READY_MAX_DATA_AGE_IN_SEC = 900
+)
+
+type componentHealth struct {
+	Status  string      `json:"status"`
+	Message string      `json:"message,omitempty"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+type healthReport struct {
+	Status     string                     `json:"status"`
+	Components map[string]componentHealth `json:"components"`
+}
+
+type healthChecker struct {
+	appState   *state.State
+	dbMgr      db.DbManager
+	throttle   *loginThrottle
+	maxDataAge time.Duration
+	startedAt  time.Time
+}
+
+func newHealthChecker(config conf.WebServiceConf, appState *state.State, dbMgr db.DbManager,
+	throttle *loginThrottle) *healthChecker {
+	maxDataAge := config.ReadyMaxDataAgeInSec
+	if maxDataAge <= 0 {
+		maxDataAge = DEFAULT_READY_MAX_DATA_AGE_IN_SEC
+	}
+
+	return &healthChecker{appState: appState, dbMgr: dbMgr, throttle: throttle,
+		maxDataAge: time.Duration(maxDataAge) * time.Second, startedAt: time.Now()}
+}
+
+// The process is alive. The service keeps running without the db, thus a degraded db is still reported with 200.
+func Health(checker *healthChecker, group *gin.RouterGroup) {
+	group.GET("", func(c *gin.Context) {
+		c.JSON(http.StatusOK, checker.live(time.Now()))
+	})
+}
+
+// The service works: mqtt connected, space broker online, db reachable and fresh data. Returns 503 otherwise.
+func Ready(checker *healthChecker, group *gin.RouterGroup) {
+	group.GET("", func(c *gin.Context) {
+		report := checker.ready(time.Now())
+		status := http.StatusOK
+		if report.Status != HEALTH_OK {
+			status = http.StatusServiceUnavailable
+		}
+		c.JSON(status, report)
+	})
+}
+
+func (h *healthChecker) live(now time.Time) healthReport {
+	outbox := h.outboxHealth()
+	status := HEALTH_OK
+	if outbox.Status == HEALTH_DEGRADED {
+		status = HEALTH_DB_DEGRADED
+	}
+
+	return healthReport{Status: status, Components: map[string]componentHealth{
+		"process": {Status: HEALTH_OK, Details: gin.H{"uptimeInSec": int64(now.Sub(h.startedAt).Seconds())}},
+		"db":      outbox,
+		"switch":  h.switchHealth(now),
+	}}
+}
+
+func (h *healthChecker) ready(now time.Time) healthReport {
+	components := map[string]componentHealth{
+		"mqtt":        h.mqttHealth(),
+		"spaceBroker": h.spaceBrokerHealth(),
+		"db":          h.dbHealth(),
+		"data":        h.dataHealth(now),
+	}
+
+	status := HEALTH_OK
+	for _, component := range components {
+		if component.Status == HEALTH_FAILED {
+			status = HEALTH_NOT_READY
+		}
+	}
+	return healthReport{Status: status, Components: components}
+}
+
+func (h *healthChecker) outboxHealth() componentHealth {
+	dbStatus := h.dbMgr.Status()
+	if dbStatus.Degraded {
+		return componentHealth{Status: HEALTH_DEGRADED, Message: dbStatus.LastError, Details: dbStatus}
+	}
+	return componentHealth{Status: HEALTH_OK, Details: dbStatus}
+}
+
+// locked out clients hint at an attack on the switch password
+func (h *healthChecker) switchHealth(now time.Time) componentHealth {
+	status := h.throttle.status(now)
+	if status.GlobalSlowdown {
+		return componentHealth{Status: HEALTH_DEGRADED, Message: "all attempts are rate limited after too many wrong passwords",
+			Details: status}
+	}
+	if status.LockedClients > 0 {
+		return componentHealth{Status: HEALTH_DEGRADED, Message: "clients are locked out after wrong passwords",
+			Details: status}
+	}
+	return componentHealth{Status: HEALTH_OK, Details: status}
+}
+
+func (h *healthChecker) mqttHealth() componentHealth {
+	if !h.appState.Mqtt.Connected {
+		return componentHealth{Status: HEALTH_FAILED, Message: "not connected to the broker"}
+	}
+	return componentHealth{Status: HEALTH_OK}
+}
+
+func (h *healthChecker) spaceBrokerHealth() componentHealth {
+	if !h.appState.Mqtt.SpaceBrokerOnline {
+		return componentHealth{Status: HEALTH_FAILED, Message: "the space broker is offline"}
+	}
+	return componentHealth{Status: HEALTH_OK}
+}
+
+// queued writes are fine as long as the db is reachable again
+func (h *healthChecker) dbHealth() componentHealth {
+	if err := h.dbMgr.Ping(); err != nil {
+		return componentHealth{Status: HEALTH_FAILED, Message: err.Error(), Details: h.dbMgr.Status()}
+	}
+	return componentHealth{Status: HEALTH_OK, Details: h.dbMgr.Status()}
+}
+
+// the devices and the newest power value must be younger than maxDataAge
+func (h *healthChecker) dataHealth(now time.Time) componentHealth {
+	devicesTs := h.appState.SpaceDevices.Timestamp
+	powerTs := int64(0)
+	for _, meter := range state.AllPowerMeters {
+		if value, err := h.appState.PowerUsage.ForMeter(meter); err == nil && value.Timestamp > powerTs {
+			powerTs = value.Timestamp
+		}
+	}
+
+	maxAge := int64(h.maxDataAge.Seconds())
+	devicesAge := now.Unix() - devicesTs
+	powerAge := now.Unix() - powerTs
+	details := gin.H{"devicesAgeInSec": devicesAge, "powerAgeInSec": powerAge, "maxAgeInSec": maxAge}
+	switch {
+	case devicesTs == 0 || devicesAge > maxAge:
+		return componentHealth{Status: HEALTH_FAILED, Message: "the devices data is outdated", Details: details}
+	case powerTs == 0 || powerAge > maxAge:
+		return componentHealth{Status: HEALTH_FAILED, Message: "the power data is outdated", Details: details}
+	}
+	return componentHealth{Status: HEALTH_OK, Details: details}
+}
+
+// Notifies systemd that the service is ready, call it when the web server listens on addr. With WatchdogSec in the
+// service file, the watchdog is kept alive while the web server answers /healthz. Mqtt or db outages don't stop the
+// watchdog, a restart doesn't fix them and the clients reconnect by themselves.
+// Nothing is sent if the service is not started by systemd.
+func startWatchdog(addr net.Addr) {
+	if sent, err := systemd.Notify(systemd.NOTIFY_READY); err != nil {
+		healthLogger.WithError(err).Warn("Can't notify systemd.")
+	} else if !sent {
+		return
+	}
+
+	interval := systemd.WatchdogInterval()
+	if interval == 0 {
+		return
+	}
+	healthLogger.WithField("interval", interval).Info("Starting the systemd watchdog.")
+	healthUrl := "http://" + loopbackAddr(addr) + "/healthz"
+	client := &http.Client{Timeout: interval / 2}
+	go func() {
+		for range time.Tick(interval / 2) {
+			if err := checkHealthz(client, healthUrl); err != nil {
+				healthLogger.WithError(err).Warn("Not notifying the watchdog.")
+				continue
+			}
+			if _, err := systemd.Notify(systemd.NOTIFY_WATCHDOG); err != nil {
+				healthLogger.WithError(err).Warn("Can't notify the watchdog.")
+			}
+		}
+	}()
+}
+
+func checkHealthz(client *http.Client, healthUrl string) error {
+	response, err := client.Get(healthUrl)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("/healthz returned %d", response.StatusCode)
+	}
+	return nil
+}
+
+// the address to reach the listener from this host, localhost if it listens on all addresses
+func loopbackAddr(addr net.Addr) string {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok || !tcpAddr.IP.IsUnspecified() {
+		return addr.String()
+	}
+	return net.JoinHostPort("localhost", strconv.Itoa(tcpAddr.Port))
+}
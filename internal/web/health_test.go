@@ -0,0 +1,147 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ktt-ol/status2/internal/conf"
+	"github.com/ktt-ol/status2/internal/db"
+	"github.com/ktt-ol/status2/internal/state"
+	"github.com/stretchr/testify/require"
+)
+
+type healthDb struct {
+	db.DbManager
+	status  db.DbStatus
+	pingErr error
+}
+
+func (h *healthDb) Status() db.DbStatus {
+	return h.status
+}
+
+func (h *healthDb) Ping() error {
+	return h.pingErr
+}
+
+func readyState(now time.Time) *state.State {
+	st := state.NewDefaultState()
+	st.Mqtt.Connected = true
+	st.Mqtt.SpaceBrokerOnline = true
+	st.SpaceDevices.Timestamp = now.Unix() - 60
+	st.PowerUsage.Back.Timestamp = now.Unix() - 5
+	return st
+}
+
+func Test_healthChecker_ready(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	st := readyState(now)
+	dbMgr := &healthDb{}
+	checker := newHealthChecker(conf.WebServiceConf{ReadyMaxDataAgeInSec: 300}, st, dbMgr, newLoginThrottle())
+
+	report := checker.ready(now)
+	require.Equal(t, HEALTH_OK, report.Status)
+	require.Len(t, report.Components, 4)
+	for _, component := range report.Components {
+		require.Equal(t, HEALTH_OK, component.Status)
+	}
+
+	st.Mqtt.SpaceBrokerOnline = false
+	dbMgr.pingErr = errors.New("connection refused")
+	report = checker.ready(now)
+	require.Equal(t, HEALTH_NOT_READY, report.Status)
+	require.Equal(t, HEALTH_OK, report.Components["mqtt"].Status)
+	require.Equal(t, HEALTH_FAILED, report.Components["spaceBroker"].Status)
+	require.Equal(t, HEALTH_FAILED, report.Components["db"].Status)
+	require.Equal(t, "connection refused", report.Components["db"].Message)
+
+	// outdated data
+	report = checker.ready(now.Add(301 * time.Second))
+	require.Equal(t, HEALTH_FAILED, report.Components["data"].Status)
+	require.Equal(t, "the devices data is outdated", report.Components["data"].Message)
+
+	st.SpaceDevices.Timestamp = now.Unix()
+	report = checker.ready(now.Add(200 * time.Second))
+	require.Equal(t, HEALTH_OK, report.Components["data"].Status)
+	st.PowerUsage.Back.Timestamp = 0
+	report = checker.ready(now)
+	require.Equal(t, "the power data is outdated", report.Components["data"].Message)
+}
+
+func Test_healthChecker_live(t *testing.T) {
+	now := time.Now()
+	dbMgr := &healthDb{}
+	// neither mqtt nor data
+	checker := newHealthChecker(conf.WebServiceConf{}, state.NewDefaultState(), dbMgr, newLoginThrottle())
+	require.Equal(t, time.Duration(DEFAULT_READY_MAX_DATA_AGE_IN_SEC)*time.Second, checker.maxDataAge)
+
+	report := checker.live(now)
+	require.Equal(t, HEALTH_OK, report.Status)
+	require.Equal(t, HEALTH_OK, report.Components["process"].Status)
+
+	dbMgr.status = db.DbStatus{Degraded: true, PendingWrites: 2, LastError: "db down"}
+	report = checker.live(now)
+	require.Equal(t, HEALTH_DB_DEGRADED, report.Status)
+	require.Equal(t, HEALTH_DEGRADED, report.Components["db"].Status)
+	require.Equal(t, "db down", report.Components["db"].Message)
+}
+
+func Test_Ready_httpStatus(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	st := readyState(time.Now())
+	router := gin.New()
+	checker := newHealthChecker(conf.WebServiceConf{}, st, &healthDb{}, newLoginThrottle())
+	Health(checker, router.Group("/healthz"))
+	Ready(checker, router.Group("/readyz"))
+
+	get := func(path string) (int, healthReport) {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+		var report healthReport
+		require.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &report))
+		return recorder.Code, report
+	}
+
+	code, report := get("/readyz")
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, HEALTH_OK, report.Status)
+
+	st.Mqtt.Connected = false
+	code, report = get("/readyz")
+	require.Equal(t, http.StatusServiceUnavailable, code)
+	require.Equal(t, "not connected to the broker", report.Components["mqtt"].Message)
+
+	code, _ = get("/healthz")
+	require.Equal(t, http.StatusOK, code)
+}
+
+func Test_checkHealthz(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	Health(newHealthChecker(conf.WebServiceConf{}, state.NewDefaultState(), &healthDb{}, newLoginThrottle()),
+		router.Group("/healthz"))
+	listener, err := net.Listen("tcp", "0.0.0.0:0")
+	require.Nil(t, err)
+	server := &http.Server{Handler: router}
+	go server.Serve(listener)
+	defer server.Close()
+
+	addr := loopbackAddr(listener.Addr())
+	require.True(t, strings.HasPrefix(addr, "localhost:"), addr)
+	client := &http.Client{Timeout: time.Second}
+	// mqtt is not connected, the web server answers anyway
+	require.Nil(t, checkHealthz(client, "http://"+addr+"/healthz"))
+	require.NotNil(t, checkHealthz(client, "http://"+addr+"/missing"))
+}
+
+func Test_loopbackAddr(t *testing.T) {
+	require.Equal(t, "127.0.0.1:9000", loopbackAddr(&net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 9000}))
+	require.Equal(t, "localhost:9000", loopbackAddr(&net.TCPAddr{IP: net.IPv6unspecified, Port: 9000}))
+}
@@ -0,0 +1,205 @@
+package web
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// wrong passwords per ip before the first lockout
+	THROTTLE_FREE_FAILURES = 3
+	// the first lockout, doubled with every further wrong password
+	THROTTLE_BASE_LOCKOUT = 30 * time.Second
+	THROTTLE_MAX_LOCKOUT  = time.Hour
+	// more wrong passwords from all ips within the window limit all attempts to one per THROTTLE_GLOBAL_DELAY, e.g. for
+	// a distributed attack. The attempts wait for their turn up to THROTTLE_GLOBAL_MAX_WAIT, the others have to retry
+	// later. Nobody is locked out by this, thus the keyholder can still switch with the right password.
+	THROTTLE_GLOBAL_FAILURES = 50
+	THROTTLE_GLOBAL_WINDOW   = 10 * time.Minute
+	THROTTLE_GLOBAL_DELAY    = 2 * time.Second
+	THROTTLE_GLOBAL_MAX_WAIT = 10 * time.Second
+	// the failures of an ip are forgotten after this time without a wrong password
+	THROTTLE_FORGET_AFTER = 24 * time.Hour
+)
+
+type clientFailures struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+type throttleStatus struct {
+	LockedClients  int  `json:"lockedClients"`
+	GlobalSlowdown bool `json:"globalSlowdown"`
+	// the wrong passwords of all ips within the global window, up to THROTTLE_GLOBAL_FAILURES
+	RecentFailures int `json:"recentFailures"`
+}
+
+// Limits the password attempts per ip and globally, safe for concurrent use.
+type loginThrottle struct {
+	lock    sync.Mutex
+	clients map[string]*clientFailures
+	// the newest wrong passwords of all ips, at most THROTTLE_GLOBAL_FAILURES
+	globalFailures []time.Time
+	// during the global slowdown the earliest time for the next attempt
+	nextGlobalSlot time.Time
+}
+
+func newLoginThrottle() *loginThrottle {
+	return &loginThrottle{clients: make(map[string]*clientFailures)}
+}
+
+// Reserves an attempt of the ip. The attempt counts as wrong password until success is called, thus parallel requests
+// can't try more passwords than the lockout allows. Returns the time until the ip may try again if it's locked out or
+// the global slowdown has no free slot, otherwise the delay before the password should be checked.
+func (t *loginThrottle) attempt(ip string, now time.Time) (retryAfter time.Duration, delay time.Duration) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.cleanup(now)
+
+	client, ok := t.clients[ip]
+	if ok && now.Before(client.lockedUntil) {
+		return client.lockedUntil.Sub(now), 0
+	}
+	if t.globalSlowdown(now) {
+		// the attempts get consecutive slots, also the parallel ones
+		slot := t.nextGlobalSlot
+		if slot.Before(now) {
+			slot = now
+		}
+		if slot.Sub(now) > THROTTLE_GLOBAL_MAX_WAIT {
+			return slot.Sub(now) - THROTTLE_GLOBAL_MAX_WAIT, 0
+		}
+		delay = slot.Sub(now)
+		t.nextGlobalSlot = slot.Add(THROTTLE_GLOBAL_DELAY)
+	}
+	if !ok {
+		client = &clientFailures{}
+		t.clients[ip] = client
+	}
+	client.failures++
+	client.lastFailure = now
+	if client.failures >= THROTTLE_FREE_FAILURES {
+		client.lockedUntil = now.Add(lockoutDuration(client.failures - THROTTLE_FREE_FAILURES))
+	}
+
+	t.globalFailures = append(t.globalFailures, now)
+	if len(t.globalFailures) > THROTTLE_GLOBAL_FAILURES {
+		t.globalFailures = t.globalFailures[1:]
+	}
+	return 0, delay
+}
+
+// the attempt of the ip at attemptedAt was the right password
+func (t *loginThrottle) success(ip string, attemptedAt time.Time) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	delete(t.clients, ip)
+	for i := len(t.globalFailures) - 1; i >= 0; i-- {
+		if t.globalFailures[i].Equal(attemptedAt) {
+			t.globalFailures = append(t.globalFailures[:i], t.globalFailures[i+1:]...)
+			break
+		}
+	}
+}
+
+func (t *loginThrottle) status(now time.Time) throttleStatus {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.cleanup(now)
+	status := throttleStatus{GlobalSlowdown: t.globalSlowdown(now), RecentFailures: len(t.globalFailures)}
+	for _, client := range t.clients {
+		if now.Before(client.lockedUntil) {
+			status.LockedClients++
+		}
+	}
+	return status
+}
+
+// the lock must be held
+func (t *loginThrottle) globalSlowdown(now time.Time) bool {
+	return len(t.globalFailures) >= THROTTLE_GLOBAL_FAILURES &&
+		now.Sub(t.globalFailures[len(t.globalFailures)-THROTTLE_GLOBAL_FAILURES]) <= THROTTLE_GLOBAL_WINDOW
+}
+
+// drops old failures, the lock must be held
+func (t *loginThrottle) cleanup(now time.Time) {
+	for ip, client := range t.clients {
+		if now.Sub(client.lastFailure) > THROTTLE_FORGET_AFTER && !now.Before(client.lockedUntil) {
+			delete(t.clients, ip)
+		}
+	}
+
+	recent := 0
+	for recent < len(t.globalFailures) && now.Sub(t.globalFailures[recent]) > THROTTLE_GLOBAL_WINDOW {
+		recent++
+	}
+	t.globalFailures = t.globalFailures[recent:]
+}
+
+// the lockout for the nth failure after the free ones
+func lockoutDuration(n int) time.Duration {
+	lockout := THROTTLE_BASE_LOCKOUT
+	for i := 0; i < n && lockout < THROTTLE_MAX_LOCKOUT; i++ {
+		lockout *= 2
+	}
+	if lockout > THROTTLE_MAX_LOCKOUT {
+		return THROTTLE_MAX_LOCKOUT
+	}
+	return lockout
+}
+
+// constant time, also for different lengths
+func passwordEquals(given string, expected string) bool {
+	givenHash := sha256.Sum256([]byte(given))
+	expectedHash := sha256.Sum256([]byte(expected))
+	return subtle.ConstantTimeCompare(givenHash[:], expectedHash[:]) == 1
+}
+
+// The ip the throttle uses for the client. gin's ClientIP() takes X-Forwarded-For and X-Real-Ip from every client, thus
+// a client could claim another ip with every request. The headers are only used if the request comes from a trusted
+// proxy.
+func throttleClientIp(c *gin.Context, trustedProxies []string) string {
+	remoteIp, _, err := net.SplitHostPort(strings.TrimSpace(c.Request.RemoteAddr))
+	if err != nil {
+		remoteIp = strings.TrimSpace(c.Request.RemoteAddr)
+	}
+	if !isTrustedProxy(remoteIp, trustedProxies) {
+		return remoteIp
+	}
+
+	// every proxy appends the address it got the request from, the left entries are set by the client
+	forwarded := strings.Split(c.GetHeader("X-Forwarded-For"), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		ip := strings.TrimSpace(forwarded[i])
+		if ip != "" && !isTrustedProxy(ip, trustedProxies) {
+			return ip
+		}
+	}
+	if realIp := strings.TrimSpace(c.GetHeader("X-Real-Ip")); realIp != "" {
+		return realIp
+	}
+	return remoteIp
+}
+
+func isTrustedProxy(ip string, trustedProxies []string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, proxy := range trustedProxies {
+		if parsed.Equal(net.ParseIP(proxy)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,224 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ktt-ol/status2/internal/conf"
+	"github.com/ktt-ol/status2/internal/state"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_loginThrottle(t *testing.T) {
+	throttle := newLoginThrottle()
+	now := time.Unix(1500000000, 0)
+
+	for i := 0; i < THROTTLE_FREE_FAILURES; i++ {
+		retryAfter, delay := throttle.attempt("1.2.3.4", now)
+		require.Equal(t, time.Duration(0), retryAfter)
+		require.Equal(t, time.Duration(0), delay)
+	}
+	retryAfter, _ := throttle.attempt("1.2.3.4", now)
+	require.Equal(t, THROTTLE_BASE_LOCKOUT, retryAfter)
+	retryAfter, _ = throttle.attempt("1.2.3.4", now.Add(20*time.Second))
+	require.Equal(t, 10*time.Second, retryAfter)
+	// other ips are not affected
+	retryAfter, _ = throttle.attempt("5.6.7.8", now)
+	require.Equal(t, time.Duration(0), retryAfter)
+	throttle.success("5.6.7.8", now)
+	require.Equal(t, throttleStatus{LockedClients: 1, RecentFailures: THROTTLE_FREE_FAILURES}, throttle.status(now))
+
+	now = now.Add(THROTTLE_BASE_LOCKOUT)
+	retryAfter, _ = throttle.attempt("1.2.3.4", now)
+	require.Equal(t, time.Duration(0), retryAfter)
+	retryAfter, _ = throttle.attempt("1.2.3.4", now)
+	require.Equal(t, 2*THROTTLE_BASE_LOCKOUT, retryAfter)
+
+	throttle.success("1.2.3.4", now)
+	retryAfter, _ = throttle.attempt("1.2.3.4", now)
+	require.Equal(t, time.Duration(0), retryAfter)
+	throttle.success("1.2.3.4", now)
+	require.Equal(t, 0, throttle.status(now).LockedClients)
+}
+
+// parallel requests get no more attempts than sequential ones
+func Test_loginThrottle_parallel(t *testing.T) {
+	throttle := newLoginThrottle()
+	now := time.Unix(1500000000, 0)
+
+	var allowed int32
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if retryAfter, _ := throttle.attempt("1.2.3.4", now); retryAfter == 0 {
+				atomic.AddInt32(&allowed, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, int32(THROTTLE_FREE_FAILURES), allowed)
+}
+
+func Test_loginThrottle_global(t *testing.T) {
+	throttle := newLoginThrottle()
+	now := time.Unix(1500000000, 0)
+
+	for i := 0; i < THROTTLE_GLOBAL_FAILURES; i++ {
+		_, delay := throttle.attempt(fmt.Sprintf("10.0.0.%d", i), now)
+		require.Equal(t, time.Duration(0), delay)
+	}
+	require.True(t, throttle.status(now).GlobalSlowdown)
+	require.Equal(t, THROTTLE_GLOBAL_FAILURES, throttle.status(now).RecentFailures)
+
+	// nobody is locked out, the attempts wait for their turn
+	retryAfter, delay := throttle.attempt("5.6.7.8", now)
+	require.Equal(t, time.Duration(0), retryAfter)
+	require.Equal(t, time.Duration(0), delay)
+	retryAfter, delay = throttle.attempt("5.6.7.9", now)
+	require.Equal(t, time.Duration(0), retryAfter)
+	require.Equal(t, THROTTLE_GLOBAL_DELAY, delay)
+	throttle.success("5.6.7.8", now)
+
+	later := now.Add(THROTTLE_GLOBAL_WINDOW + time.Second)
+	_, delay = throttle.attempt("5.6.7.8", later)
+	require.Equal(t, time.Duration(0), delay)
+	throttle.success("5.6.7.8", later)
+	require.Equal(t, throttleStatus{}, throttle.status(later))
+}
+
+// during the global slowdown parallel attempts from many ips get one slot per THROTTLE_GLOBAL_DELAY
+func Test_loginThrottle_globalParallel(t *testing.T) {
+	throttle := newLoginThrottle()
+	now := time.Unix(1500000000, 0)
+	for i := 0; i < THROTTLE_GLOBAL_FAILURES; i++ {
+		throttle.attempt(fmt.Sprintf("10.0.0.%d", i), now)
+	}
+
+	var lock sync.Mutex
+	var delays []time.Duration
+	var rejected int32
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			retryAfter, delay := throttle.attempt(fmt.Sprintf("10.1.0.%d", i), now)
+			if retryAfter > 0 {
+				atomic.AddInt32(&rejected, 1)
+				return
+			}
+			lock.Lock()
+			delays = append(delays, delay)
+			lock.Unlock()
+		}(i)
+	}
+	wg.Wait()
+
+	allowed := int(THROTTLE_GLOBAL_MAX_WAIT/THROTTLE_GLOBAL_DELAY) + 1
+	require.Len(t, delays, allowed)
+	require.Equal(t, int32(20-allowed), rejected)
+	sort.Slice(delays, func(i, j int) bool { return delays[i] < delays[j] })
+	for i, delay := range delays {
+		require.Equal(t, time.Duration(i)*THROTTLE_GLOBAL_DELAY, delay)
+	}
+
+	// every THROTTLE_GLOBAL_DELAY one more attempt fits into the maximum wait
+	retryAfter, _ := throttle.attempt("10.2.0.1", now.Add(THROTTLE_GLOBAL_DELAY))
+	require.Equal(t, time.Duration(0), retryAfter)
+	retryAfter, _ = throttle.attempt("10.2.0.2", now.Add(THROTTLE_GLOBAL_DELAY))
+	require.True(t, retryAfter > 0)
+}
+
+func Test_loginThrottle_cleanup(t *testing.T) {
+	throttle := newLoginThrottle()
+	now := time.Unix(1500000000, 0)
+
+	throttle.attempt("1.2.3.4", now)
+	throttle.attempt("1.2.3.4", now.Add(THROTTLE_FORGET_AFTER+time.Second))
+	require.Len(t, throttle.clients, 1)
+	// the first failure is forgotten, thus no lockout after the next one
+	later := now.Add(2*THROTTLE_FORGET_AFTER + 2*time.Second)
+	throttle.attempt("1.2.3.4", later)
+	retryAfter, _ := throttle.attempt("1.2.3.4", later)
+	require.Equal(t, time.Duration(0), retryAfter)
+}
+
+func Test_throttleClientIp(t *testing.T) {
+	trusted := []string{"127.0.0.1", "10.0.0.1"}
+	clientIp := func(remoteAddr string, forwardedFor string, realIp string) string {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodPost, "/switch", nil)
+		c.Request.RemoteAddr = remoteAddr
+		if forwardedFor != "" {
+			c.Request.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		if realIp != "" {
+			c.Request.Header.Set("X-Real-Ip", realIp)
+		}
+		return throttleClientIp(c, trusted)
+	}
+
+	require.Equal(t, "1.2.3.4", clientIp("1.2.3.4:1234", "", ""))
+	// the headers of other clients are ignored
+	require.Equal(t, "1.2.3.4", clientIp("1.2.3.4:1234", "5.6.7.8", "5.6.7.8"))
+	require.Equal(t, "5.6.7.8", clientIp("127.0.0.1:1234", "5.6.7.8", ""))
+	// the client can prepend addresses, the proxies append the real one
+	require.Equal(t, "5.6.7.8", clientIp("127.0.0.1:1234", "9.9.9.9, 5.6.7.8, 10.0.0.1", ""))
+	require.Equal(t, "5.6.7.8", clientIp("127.0.0.1:1234", "", "5.6.7.8"))
+	require.Equal(t, "127.0.0.1", clientIp("127.0.0.1:1234", "", ""))
+}
+
+func Test_SwitchPage_spoofedForwardedFor(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	SwitchPage(conf.WebServiceConf{SwitchPassword: "secret"}, state.NewDefaultState(), nil, newStateNotes(""),
+		newAuditLog(""), newLoginThrottle(), router.Group("/switch"))
+
+	post := func(i int) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodPost, "/switch/space", strings.NewReader("password=wrong&state=open"))
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		request.RemoteAddr = "1.2.3.4:1234"
+		request.Header.Set("X-Forwarded-For", fmt.Sprintf("10.1.0.%d", i))
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	for i := 0; i < THROTTLE_FREE_FAILURES; i++ {
+		require.Equal(t, http.StatusUnauthorized, post(i).Code)
+	}
+	recorder := post(THROTTLE_FREE_FAILURES)
+	require.Equal(t, http.StatusTooManyRequests, recorder.Code)
+	require.Equal(t, "30", recorder.Header().Get("Retry-After"))
+}
+
+func Test_lockoutDuration(t *testing.T) {
+	require.Equal(t, THROTTLE_BASE_LOCKOUT, lockoutDuration(0))
+	require.Equal(t, 4*THROTTLE_BASE_LOCKOUT, lockoutDuration(2))
+	require.Equal(t, THROTTLE_MAX_LOCKOUT, lockoutDuration(20))
+	require.Equal(t, THROTTLE_MAX_LOCKOUT, lockoutDuration(1000))
+}
+
+func Test_passwordEquals(t *testing.T) {
+	require.True(t, passwordEquals("secret", "secret"))
+	require.False(t, passwordEquals("secre", "secret"))
+	require.False(t, passwordEquals("", "secret"))
+}
+
+func Test_redactedQuery(t *testing.T) {
+	query, _ := url.ParseQuery("place=space&password=secret")
+	require.Equal(t, "password=%2A%2A%2A&place=space", redactedQuery(query))
+	query, _ = url.ParseQuery("place=space")
+	require.Equal(t, "place=space", redactedQuery(query))
+}
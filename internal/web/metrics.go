@@ -0,0 +1,80 @@
+package web
+
+import (
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ktt-ol/status2/internal/metrics"
+	"github.com/ktt-ol/status2/internal/state"
+	"github.com/sirupsen/logrus"
+)
+
+var metricsLogger = logrus.WithField("where", "Metrics")
+
+// The current state and the counters in the Prometheus text format.
+func Metrics(appState *state.State, throttle *loginThrottle, group *gin.RouterGroup) {
+	group.GET("", func(c *gin.Context) {
+		c.Header("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		c.Status(http.StatusOK)
+		if err := writeMetrics(c.Writer, appState, throttle.status(time.Now())); err != nil {
+			metricsLogger.WithError(err).Warn("Can't write the metrics.")
+		}
+	})
+}
+
+func writeMetrics(writer io.Writer, appState *state.State, throttle throttleStatus) error {
+	w := metrics.NewWriter(writer)
+
+	var open, openStates []metrics.Sample
+	for _, place := range state.AllPlaces {
+		value, err := appState.Open.ForPlace(place)
+		if err != nil {
+			return err
+		}
+		placeLabel := metrics.Label{Name: "place", Value: place.StrValue()}
+		open = append(open, metrics.Sample{Labels: []metrics.Label{placeLabel}, Value: metrics.Bool(value.Value.IsPublicOpen())})
+		openStates = append(openStates, metrics.Sample{
+			Labels: []metrics.Label{placeLabel, {Name: "state", Value: string(value.Value)}},
+			Value:  1,
+		})
+	}
+	w.Gauge("status2_place_open", "1 if the place is open for the public.", open...)
+	w.Gauge("status2_place_state", "The current open state of the place.", openStates...)
+
+	devices := appState.SpaceDevices
+	w.Gauge("status2_people", "People in the space.", metrics.Sample{Value: float64(devices.PeopleCount)})
+	w.Gauge("status2_devices", "Devices in the space.", metrics.Sample{Value: float64(devices.DeviceCount)})
+	w.Gauge("status2_unknown_devices", "Unknown devices in the space.",
+		metrics.Sample{Value: float64(devices.UnknownDevicesCount)})
+
+	var power []metrics.Sample
+	for _, meter := range state.AllPowerMeters {
+		value, err := appState.PowerUsage.ForMeter(meter)
+		if err != nil {
+			return err
+		}
+		power = append(power, metrics.Sample{
+			Labels: []metrics.Label{{Name: "meter", Value: string(meter)}},
+			Value:  value.Value,
+		})
+	}
+	// the state values are always in watt
+	w.Gauge("status2_power_watts", "The current power consumption.", power...)
+
+	w.Gauge("status2_mqtt_connected", "1 if connected to the mqtt broker.",
+		metrics.Sample{Value: metrics.Bool(appState.Mqtt.Connected)})
+	w.Gauge("status2_mqtt_broker_online", "1 if the space broker is online.",
+		metrics.Sample{Value: metrics.Bool(appState.Mqtt.SpaceBrokerOnline)})
+	w.Gauge("status2_sse_clients", "Connected status stream clients.",
+		metrics.Sample{Value: float64(metrics.SseClients.Value())})
+	w.Gauge("status2_switch_locked_clients", "Clients locked out of the switch page after wrong passwords.",
+		metrics.Sample{Value: float64(throttle.LockedClients)})
+	w.Gauge("status2_switch_global_slowdown", "1 if all switch password attempts are rate limited.",
+		metrics.Sample{Value: metrics.Bool(throttle.GlobalSlowdown)})
+
+	w.Counters()
+
+	return w.Err()
+}
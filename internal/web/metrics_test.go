@@ -0,0 +1,42 @@
+package web
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/ktt-ol/status2/internal/metrics"
+	"github.com/ktt-ol/status2/internal/state"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_writeMetrics(t *testing.T) {
+	st := state.NewDefaultState()
+	st.Open.Space = &state.OpenValueTs{Value: state.OPEN_PLUS, Timestamp: 1}
+	st.SpaceDevices.PeopleCount = 3
+	st.SpaceDevices.UnknownDevicesCount = 4
+	st.PowerUsage.Back.Value = 250.5
+	st.Mqtt.Connected = true
+	metrics.SseClients.Inc()
+	defer metrics.SseClients.Dec()
+	metrics.MqttMessages.Inc("/test/topic")
+
+	var buffer bytes.Buffer
+	require.Nil(t, writeMetrics(&buffer, st, throttleStatus{LockedClients: 2}))
+	output := buffer.String()
+
+	require.Contains(t, output, "# TYPE status2_place_open gauge\n")
+	require.Contains(t, output, "status2_place_open{place=\"space\"} 1\n")
+	require.Contains(t, output, "status2_place_open{place=\"radstelle\"} 0\n")
+	require.Contains(t, output, "status2_place_state{place=\"space\",state=\"open+\"} 1\n")
+	require.Contains(t, output, "status2_people 3\n")
+	require.Contains(t, output, "status2_unknown_devices 4\n")
+	require.Contains(t, output, "status2_power_watts{meter=\"back\"} 250.5\n")
+	require.Contains(t, output, "status2_mqtt_connected 1\n")
+	require.Contains(t, output, "status2_mqtt_broker_online 0\n")
+	require.Contains(t, output, "status2_sse_clients 1\n")
+	require.Contains(t, output, "status2_switch_locked_clients 2\n")
+	require.Contains(t, output, "status2_switch_global_slowdown 0\n")
+	require.Contains(t, output, "# TYPE status2_mqtt_messages_total counter\n")
+	require.Contains(t, output, "status2_mqtt_messages_total{topic=\"/test/topic\"} 1\n")
+	require.Contains(t, output, "# TYPE status2_notifications_failed_total counter\n")
+}
@@ -0,0 +1,276 @@
+package web
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ktt-ol/status2/internal/db"
+	"github.com/sirupsen/logrus"
+)
+
+var openAnalyticsLogger = logrus.WithField("where", "OpenAnalytics")
+
+const dateFormat = "2006-01-02"
+
+type periodHours struct {
+	Start time.Time `json:"start"`
+	Hours float64   `json:"hours"`
+}
+
+type streak struct {
+	Days int `json:"days"`
+	// the first and last day (YYYY-MM-DD), empty without a streak
+	First string `json:"first"`
+	Last  string `json:"last"`
+}
+
+type yearOpenings struct {
+	Year         int        `json:"year"`
+	FirstOpening *time.Time `json:"firstOpening"`
+	LastOpening  *time.Time `json:"lastOpening"`
+}
+
+type openAnalytics struct {
+	Place db.Place  `json:"place"`
+	From  time.Time `json:"from"`
+	To    time.Time `json:"to"`
+	// weeks start on monday
+	HoursPerWeek  []periodHours `json:"hoursPerWeek"`
+	HoursPerMonth []periodHours `json:"hoursPerMonth"`
+	HoursPerYear  []periodHours `json:"hoursPerYear"`
+	// the share of the time the place was open, per weekday (0 = sunday) and hour of the day
+	Heatmap [7][24]float64 `json:"heatmap"`
+	// the durations of the finished openings
+	AverageDurationHours float64        `json:"averageDurationHours"`
+	MedianDurationHours  float64        `json:"medianDurationHours"`
+	Openings             int            `json:"openings"`
+	LongestOpenStreak    streak         `json:"longestOpenStreak"`
+	LongestClosedStreak  streak         `json:"longestClosedStreak"`
+	Years                []yearOpenings `json:"years"`
+}
+
+// Returns aggregated opening hours, based on the public open state.
+// Params: place (default: space), from, to (default: the current year), tz (IANA time zone, default: local)
+func OpenAnalytics(dbMgr db.DbManager, group *gin.RouterGroup) {
+	group.GET("", func(c *gin.Context) {
+		location, err := parseLocationParam(c.Query("tz"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		place, err := parsePlaceParam(c.Query("place"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		now := time.Now().In(location)
+		to, err := parseTimeParam(c.Query("to"), now, location)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		from, err := parseTimeParam(c.Query("from"), time.Date(now.Year(), 1, 1, 0, 0, 0, 0, location), location)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if now.Before(to) {
+			to = now
+		}
+		if !from.Before(to) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid range, from must be before to."})
+			return
+		}
+
+		openStates, err := dbMgr.GetOpenStates(place, from, to)
+		if err != nil {
+			openAnalyticsLogger.WithError(err).Warn("Can't read the open states.")
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "db not available"})
+			return
+		}
+
+		analytics := analyzeOpenEntries(normalizeResults(openStates), from.In(location), to.In(location))
+		analytics.Place = place
+		c.JSON(http.StatusOK, analytics)
+	})
+}
+
+// The entries must be ordered, an entry without end is still open. The hours are counted in [from, to), in the
+// location of from. The durations and first openings only count the openings that began in the range, an opening
+// before from would have a cut duration and a begin that was no opening.
+func analyzeOpenEntries(entries []*entry, from time.Time, to time.Time) openAnalytics {
+	location := from.Location()
+	result := openAnalytics{From: from, To: to, Years: make([]yearOpenings, 0)}
+
+	weeks := make(map[int64]float64)
+	months := make(map[int64]float64)
+	years := make(map[int64]float64)
+	var openSeconds [7][24]float64
+	durations := make([]float64, 0, len(entries))
+	openDays := make(map[string]bool)
+
+	for _, e := range clipEntries(entries, from, to, location) {
+		addPeriodHours(weeks, *e.begin, *e.end, weekPeriod)
+		addPeriodHours(months, *e.begin, *e.end, monthPeriod)
+		addPeriodHours(years, *e.begin, *e.end, yearPeriod)
+		forEachHour(*e.begin, *e.end, func(start time.Time, seconds float64) {
+			openSeconds[start.Weekday()][start.Hour()] += seconds
+		})
+		for day := dayStart(*e.begin); day.Before(*e.end); day = day.AddDate(0, 0, 1) {
+			openDays[day.Format(dateFormat)] = true
+		}
+	}
+
+	for _, e := range entries {
+		if e.begin.Before(from) || !e.begin.Before(to) {
+			continue
+		}
+		if e.end != nil {
+			durations = append(durations, e.end.Sub(*e.begin).Hours())
+		}
+
+		begin := e.begin.In(location)
+		year := begin.Year()
+		if len(result.Years) == 0 || result.Years[len(result.Years)-1].Year != year {
+			result.Years = append(result.Years, yearOpenings{Year: year, FirstOpening: &begin})
+		}
+		result.Years[len(result.Years)-1].LastOpening = &begin
+	}
+
+	result.HoursPerWeek = sortedPeriodHours(weeks, location)
+	result.HoursPerMonth = sortedPeriodHours(months, location)
+	result.HoursPerYear = sortedPeriodHours(years, location)
+
+	var totalSeconds [7][24]float64
+	forEachHour(from, to, func(start time.Time, seconds float64) {
+		totalSeconds[start.Weekday()][start.Hour()] += seconds
+	})
+	for weekday := range totalSeconds {
+		for hour := range totalSeconds[weekday] {
+			if totalSeconds[weekday][hour] > 0 {
+				result.Heatmap[weekday][hour] = openSeconds[weekday][hour] / totalSeconds[weekday][hour]
+			}
+		}
+	}
+
+	result.Openings = len(durations)
+	if len(durations) > 0 {
+		sum := 0.0
+		for _, duration := range durations {
+			sum += duration
+		}
+		result.AverageDurationHours = sum / float64(len(durations))
+		result.MedianDurationHours = median(durations)
+	}
+
+	result.LongestOpenStreak, result.LongestClosedStreak = longestStreaks(openDays, from, to)
+
+	return result
+}
+
+// a week, month or year
+type period struct {
+	start               func(ts time.Time) time.Time
+	years, months, days int
+}
+
+var (
+	weekPeriod  = period{weekStart, 0, 0, 7}
+	monthPeriod = period{monthStart, 0, 1, 0}
+	yearPeriod  = period{yearStart, 1, 0, 0}
+)
+
+// adds the hours of [begin, end) to the periods, the keys are the unix start of the periods
+func addPeriodHours(periods map[int64]float64, begin time.Time, end time.Time, p period) {
+	for begin.Before(end) {
+		start := p.start(begin)
+		next := start.AddDate(p.years, p.months, p.days)
+		if end.Before(next) {
+			next = end
+		}
+		periods[start.Unix()] += next.Sub(begin).Hours()
+		begin = next
+	}
+}
+
+func sortedPeriodHours(periods map[int64]float64, location *time.Location) []periodHours {
+	result := make([]periodHours, 0, len(periods))
+	for start, hours := range periods {
+		result = append(result, periodHours{Start: time.Unix(start, 0).In(location), Hours: hours})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Start.Before(result[j].Start)
+	})
+
+	return result
+}
+
+// calls the handler for every local hour in [begin, end) with the start of the hour and the seconds of [begin, end)
+// in that hour
+func forEachHour(begin time.Time, end time.Time, handler func(start time.Time, seconds float64)) {
+	for begin.Before(end) {
+		start := hourStart(begin)
+		next := start.Add(time.Hour)
+		if end.Before(next) {
+			next = end
+		}
+		handler(start, next.Sub(begin).Seconds())
+		begin = next
+	}
+}
+
+// the longest runs of days with and without an opening in [from, to)
+func longestStreaks(openDays map[string]bool, from time.Time, to time.Time) (streak, streak) {
+	var longestOpen, longestClosed, current streak
+	currentOpen := false
+	for day := dayStart(from); day.Before(to); day = day.AddDate(0, 0, 1) {
+		date := day.Format(dateFormat)
+		isOpen := openDays[date]
+		if current.Days == 0 || isOpen != currentOpen {
+			current = streak{First: date}
+			currentOpen = isOpen
+		}
+		current.Days++
+		current.Last = date
+
+		if isOpen && current.Days > longestOpen.Days {
+			longestOpen = current
+		}
+		if !isOpen && current.Days > longestClosed.Days {
+			longestClosed = current
+		}
+	}
+
+	return longestOpen, longestClosed
+}
+
+func median(values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	middle := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[middle-1] + sorted[middle]) / 2
+	}
+	return sorted[middle]
+}
+
+func dayStart(ts time.Time) time.Time {
+	return time.Date(ts.Year(), ts.Month(), ts.Day(), 0, 0, 0, 0, ts.Location())
+}
+
+// monday
+func weekStart(ts time.Time) time.Time {
+	daysSinceMonday := (int(ts.Weekday()) + 6) % 7
+	return time.Date(ts.Year(), ts.Month(), ts.Day()-daysSinceMonday, 0, 0, 0, 0, ts.Location())
+}
+
+func monthStart(ts time.Time) time.Time {
+	return time.Date(ts.Year(), ts.Month(), 1, 0, 0, 0, 0, ts.Location())
+}
+
+func yearStart(ts time.Time) time.Time {
+	return time.Date(ts.Year(), 1, 1, 0, 0, 0, 0, ts.Location())
+}
@@ -0,0 +1,80 @@
+package web
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_analyzeOpenEntries(t *testing.T) {
+	// monday, 2018-01-01 to sunday, 2018-01-14
+	from := *mkTestTime(2018, 1, 1, 0, 0)
+	to := *mkTestTime(2018, 1, 15, 0, 0)
+	entries := []*entry{
+		{mkTestTime(2018, 1, 1, 18, 0), mkTestTime(2018, 1, 1, 20, 0)},
+		{mkTestTime(2018, 1, 2, 18, 0), mkTestTime(2018, 1, 2, 22, 0)},
+		{mkTestTime(2018, 1, 3, 23, 0), mkTestTime(2018, 1, 4, 2, 0)},
+		// still open
+		{mkTestTime(2018, 1, 14, 20, 0), nil},
+	}
+
+	result := analyzeOpenEntries(entries, from, to)
+
+	require.Equal(t, []periodHours{{from, 9}, {from.AddDate(0, 0, 7), 4}}, result.HoursPerWeek)
+	require.Equal(t, []periodHours{{from, 13}}, result.HoursPerMonth)
+	require.Equal(t, []periodHours{{from, 13}}, result.HoursPerYear)
+
+	// two mondays in the range, one open from 18 to 20
+	require.Equal(t, 0.5, result.Heatmap[time.Monday][18])
+	require.Equal(t, 0.0, result.Heatmap[time.Monday][20])
+	require.Equal(t, 0.5, result.Heatmap[time.Thursday][1])
+	require.Equal(t, 0.5, result.Heatmap[time.Sunday][23])
+
+	// 2, 4 and 3 hours, the still open entry is not counted
+	require.Equal(t, 3, result.Openings)
+	require.Equal(t, 3.0, result.AverageDurationHours)
+	require.Equal(t, 3.0, result.MedianDurationHours)
+
+	require.Equal(t, streak{Days: 4, First: "2018-01-01", Last: "2018-01-04"}, result.LongestOpenStreak)
+	require.Equal(t, streak{Days: 9, First: "2018-01-05", Last: "2018-01-13"}, result.LongestClosedStreak)
+
+	require.Len(t, result.Years, 1)
+	require.Equal(t, 2018, result.Years[0].Year)
+	require.Equal(t, mkTestTime(2018, 1, 1, 18, 0), result.Years[0].FirstOpening)
+	require.Equal(t, mkTestTime(2018, 1, 14, 20, 0), result.Years[0].LastOpening)
+}
+
+func Test_analyzeOpenEntries_empty(t *testing.T) {
+	from := *mkTestTime(2018, 1, 1, 0, 0)
+	result := analyzeOpenEntries([]*entry{}, from, from.AddDate(0, 0, 3))
+	require.Equal(t, 0, result.Openings)
+	require.Len(t, result.HoursPerWeek, 0)
+	require.Equal(t, streak{Days: 3, First: "2018-01-01", Last: "2018-01-03"}, result.LongestClosedStreak)
+	require.Equal(t, 0, result.LongestOpenStreak.Days)
+}
+
+// an opening across from only counts for the hours
+func Test_analyzeOpenEntries_acrossFrom(t *testing.T) {
+	from := *mkTestTime(2018, 1, 1, 0, 0)
+	to := *mkTestTime(2018, 1, 8, 0, 0)
+	entries := []*entry{
+		{mkTestTime(2017, 12, 31, 20, 0), mkTestTime(2018, 1, 1, 2, 0)},
+		{mkTestTime(2018, 1, 2, 18, 0), mkTestTime(2018, 1, 2, 22, 0)},
+	}
+
+	result := analyzeOpenEntries(entries, from, to)
+	require.Equal(t, []periodHours{{from, 6}}, result.HoursPerWeek)
+	require.Equal(t, 1.0, result.Heatmap[time.Monday][1])
+	require.Equal(t, 1, result.Openings)
+	require.Equal(t, 4.0, result.AverageDurationHours)
+	require.Equal(t, 4.0, result.MedianDurationHours)
+	require.Equal(t, streak{Days: 2, First: "2018-01-01", Last: "2018-01-02"}, result.LongestOpenStreak)
+	require.Len(t, result.Years, 1)
+	require.Equal(t, mkTestTime(2018, 1, 2, 18, 0), result.Years[0].FirstOpening)
+}
+
+func Test_median(t *testing.T) {
+	require.Equal(t, 2.0, median([]float64{3, 1, 2}))
+	require.Equal(t, 2.5, median([]float64{4, 1, 3, 2}))
+}
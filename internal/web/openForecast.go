@@ -0,0 +1,190 @@
+package web
+
+import (
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ktt-ol/status2/internal/conf"
+	"github.com/ktt-ol/status2/internal/db"
+	"github.com/sirupsen/logrus"
+)
+
+var forecastLogger = logrus.WithField("where", "OpenForecast")
+
+const (
+	DEFAULT_FORECAST_LOOKBACK_WEEKS = 12
+	DEFAULT_FORECAST_WEEKLY_DECAY   = 0.8
+
+	forecastHours = 7 * 24
+	// the history only changes slowly, but the first hour must be current and a failed db read is retried
+	forecastRefreshInterval = 5 * time.Minute
+)
+
+// The probability that the space is publicly open in the hour starting at Start.
+type forecastHour struct {
+	Start       time.Time `json:"start"`
+	Probability float64   `json:"probability"`
+}
+
+type openForecaster struct {
+	dbMgr         db.DbManager
+	lookbackWeeks int
+	weeklyDecay   float64
+
+	lock   sync.Mutex
+	cached []forecastHour
+
+	stopChan chan bool
+	ticker   *time.Ticker
+}
+
+func newOpenForecaster(config conf.ForecastConf, dbMgr db.DbManager) *openForecaster {
+	forecaster := &openForecaster{dbMgr: dbMgr, lookbackWeeks: config.LookbackWeeks, weeklyDecay: config.WeeklyDecay}
+	if forecaster.lookbackWeeks <= 0 {
+		forecaster.lookbackWeeks = DEFAULT_FORECAST_LOOKBACK_WEEKS
+	}
+	if forecaster.weeklyDecay <= 0 || forecaster.weeklyDecay > 1 {
+		forecaster.weeklyDecay = DEFAULT_FORECAST_WEEKLY_DECAY
+	}
+
+	return forecaster
+}
+
+// Computes the forecast in the background, once at the start and then every few minutes. The requests only read the
+// last computed forecast, thus they never wait for the db.
+func (f *openForecaster) startTimer() {
+	f.stopChan = make(chan bool)
+	ticker := time.NewTicker(forecastRefreshInterval)
+	f.ticker = ticker
+	go func() {
+		f.refresh(time.Now())
+		for {
+			select {
+			case <-ticker.C:
+				f.refresh(time.Now())
+			case <-f.stopChan:
+				return
+			}
+		}
+	}()
+}
+
+func (f *openForecaster) stopTimer() {
+	if f.ticker != nil {
+		f.ticker.Stop()
+		f.ticker = nil
+		f.stopChan <- true
+	}
+}
+
+// Returns the open probability for every hour of the next 7 days, starting with the current hour.
+// Params: tz (IANA time zone of the hours, default: local)
+func OpenForecast(forecaster *openForecaster, group *gin.RouterGroup) {
+	group.GET("", func(c *gin.Context) {
+		location, err := parseLocationParam(c.Query("tz"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		hours := forecaster.forecast(time.Now())
+		if hours == nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "forecast not available, db not available"})
+			return
+		}
+
+		result := make([]forecastHour, len(hours))
+		for i, hour := range hours {
+			result[i] = forecastHour{Start: hour.Start.In(location), Probability: hour.Probability}
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"lookbackWeeks": forecaster.lookbackWeeks,
+			"weeklyDecay":   forecaster.weeklyDecay,
+			"hours":         result,
+		})
+	})
+}
+
+// The last computed forecast without the past hours, the first hour contains now. Nil if there is none yet.
+func (f *openForecaster) forecast(now time.Time) []forecastHour {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	currentHour := hourStart(now.In(time.Local))
+	for i, hour := range f.cached {
+		if !hour.Start.Before(currentHour) {
+			return f.cached[i:]
+		}
+	}
+	return nil
+}
+
+// Computes the forecast from the history, the last forecast is kept if the db is not available.
+func (f *openForecaster) refresh(now time.Time) {
+	currentHour := hourStart(now.In(time.Local))
+	from := currentHour.AddDate(0, 0, -7*f.lookbackWeeks)
+	openStates, err := f.dbMgr.GetOpenStates(db.PLACE_SPACE, from, now)
+	if err != nil {
+		forecastLogger.WithError(err).Warn("Can't compute the forecast, keeping the last one.")
+		return
+	}
+
+	hours := forecastOpenHours(clipEntries(normalizeResults(openStates), from, now, time.Local), currentHour,
+		f.lookbackWeeks, f.weeklyDecay)
+	f.lock.Lock()
+	f.cached = hours
+	f.lock.Unlock()
+}
+
+// For every hour, the open share of the same weekday and hour of the past weeks is weighted by
+// weeklyDecay ^ (weeks ago - 1).
+func forecastOpenHours(entries []*entry, firstHour time.Time, lookbackWeeks int, weeklyDecay float64) []forecastHour {
+	openSeconds := make(map[int64]float64)
+	for _, e := range entries {
+		forEachHour(*e.begin, *e.end, func(start time.Time, seconds float64) {
+			openSeconds[start.Unix()] += seconds
+		})
+	}
+
+	result := make([]forecastHour, 0, forecastHours)
+	for i := 0; i < forecastHours; i++ {
+		hour := hourStart(firstHour.Add(time.Duration(i) * time.Hour))
+		weightedSum, weightSum := 0.0, 0.0
+		weight := 1.0
+		for week := 1; week <= lookbackWeeks; week++ {
+			// same wall clock time, also across DST changes
+			past := hour.AddDate(0, 0, -7*week)
+			share := math.Min(openSeconds[past.Unix()]/3600, 1)
+			weightedSum += weight * share
+			weightSum += weight
+			weight *= weeklyDecay
+		}
+
+		result = append(result, forecastHour{Start: hour, Probability: math.Round(weightedSum/weightSum*100) / 100})
+	}
+
+	return result
+}
+
+// the start of the local hour of ts, also for time zones with a half hour offset
+func hourStart(ts time.Time) time.Time {
+	_, offset := ts.Zone()
+	shift := time.Duration(offset) * time.Second
+	return ts.Add(shift).Truncate(time.Hour).Add(-shift)
+}
+
+// the next hours for the SpaceAPI, with unix timestamps
+func spaceApiForecast(hours []forecastHour, count int) []map[string]interface{} {
+	if len(hours) < count {
+		count = len(hours)
+	}
+	result := make([]map[string]interface{}, count)
+	for i := range result {
+		result[i] = map[string]interface{}{"start": hours[i].Start.Unix(), "probability": hours[i].Probability}
+	}
+
+	return result
+}
@@ -0,0 +1,123 @@
+package web
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ktt-ol/status2/internal/conf"
+	"github.com/ktt-ol/status2/internal/db"
+	"github.com/ktt-ol/status2/internal/state"
+	"github.com/stretchr/testify/require"
+)
+
+// counts the reads, fails while failing is set
+type forecastDb struct {
+	db.DbManager
+	reads   int32
+	failing bool
+}
+
+func (f *forecastDb) GetOpenStates(place db.Place, from time.Time, to time.Time) ([]db.OpenState, error) {
+	atomic.AddInt32(&f.reads, 1)
+	if f.failing {
+		return nil, errors.New("db not available")
+	}
+	return []db.OpenState{{Value: state.OPEN, Time: to.Add(-7 * 24 * time.Hour)}}, nil
+}
+
+func Test_forecastOpenHours(t *testing.T) {
+	// mondays
+	entries := []*entry{
+		{mkTestTime(2018, 1, 1, 18, 0), mkTestTime(2018, 1, 1, 20, 0)},
+		{mkTestTime(2018, 1, 8, 18, 0), mkTestTime(2018, 1, 8, 20, 0)},
+		{mkTestTime(2018, 1, 15, 18, 0), mkTestTime(2018, 1, 15, 19, 0)},
+		// half of the hour on a tuesday
+		{mkTestTime(2018, 1, 16, 10, 0), mkTestTime(2018, 1, 16, 10, 30)},
+	}
+	monday := *mkTestTime(2018, 1, 22, 0, 0)
+
+	hours := forecastOpenHours(entries, monday, 4, 0.5)
+	require.Len(t, hours, 7*24)
+	require.Equal(t, monday, hours[0].Start)
+	require.Equal(t, monday.Add(18*time.Hour), hours[18].Start)
+
+	// the weights are 1, 0.5, 0.25 and 0.125 (no data)
+	require.Equal(t, 0.93, hours[18].Probability)
+	require.Equal(t, 0.4, hours[19].Probability)
+	require.Equal(t, 0.0, hours[17].Probability)
+	require.Equal(t, 0.27, hours[24+10].Probability)
+}
+
+func Test_hourStart(t *testing.T) {
+	require.Equal(t, *mkTestTime(2018, 1, 1, 18, 0), hourStart(*mkTestTime(2018, 1, 1, 18, 59)))
+
+	kolkata, err := time.LoadLocation("Asia/Kolkata")
+	require.Nil(t, err)
+	ts := time.Date(2018, 1, 1, 18, 10, 0, 0, kolkata)
+	require.Equal(t, time.Date(2018, 1, 1, 18, 0, 0, 0, kolkata).Unix(), hourStart(ts).Unix())
+}
+
+func Test_openForecaster_refresh(t *testing.T) {
+	dbMock := &forecastDb{failing: true}
+	forecaster := newOpenForecaster(conf.ForecastConf{}, dbMock)
+	now := time.Now()
+
+	forecaster.refresh(now)
+	require.Nil(t, forecaster.forecast(now))
+
+	dbMock.failing = false
+	forecaster.refresh(now)
+	hours := forecaster.forecast(now)
+	require.Len(t, hours, forecastHours)
+	require.Equal(t, hourStart(now), hours[0].Start)
+
+	// the last forecast is kept while the db is not available, without the past hours
+	dbMock.failing = true
+	later := now.Add(2 * time.Hour)
+	forecaster.refresh(later)
+	hours = forecaster.forecast(later)
+	require.Len(t, hours, forecastHours-2)
+	require.Equal(t, hourStart(later), hours[0].Start)
+}
+
+// the SpaceAPI only reads the cached forecast, never the db
+func Test_SpaceInfo_forecastFromCache(t *testing.T) {
+	dbMock := &forecastDb{failing: true}
+	forecaster := newOpenForecaster(conf.ForecastConf{}, dbMock)
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	SpaceInfo(state.NewDefaultState(), forecaster, nil, router.Group("/spaceInfo"))
+
+	request := func() *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/spaceInfo", nil))
+		return recorder
+	}
+	recorder := request()
+	require.Equal(t, http.StatusOK, recorder.Code)
+	require.NotContains(t, recorder.Body.String(), "ext_open_forecast")
+	require.Equal(t, int32(0), atomic.LoadInt32(&dbMock.reads))
+
+	dbMock.failing = false
+	forecaster.refresh(time.Now())
+	recorder = request()
+	require.Contains(t, recorder.Body.String(), "ext_open_forecast")
+	require.Equal(t, int32(1), atomic.LoadInt32(&dbMock.reads))
+}
+
+func Test_openForecaster_timer(t *testing.T) {
+	dbMock := &forecastDb{}
+	forecaster := newOpenForecaster(conf.ForecastConf{}, dbMock)
+	forecaster.startTimer()
+	defer forecaster.stopTimer()
+
+	for i := 0; i < 100 && forecaster.forecast(time.Now()) == nil; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	require.NotNil(t, forecaster.forecast(time.Now()))
+}
@@ -0,0 +1,123 @@
+package web
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ktt-ol/status2/internal/db"
+	"github.com/sirupsen/logrus"
+)
+
+var icsLogger = logrus.WithField("where", "OpenStateIcs")
+
+const maxIcsRange = 366 * 24 * time.Hour
+const icsTimeFormat = "20060102T150405Z"
+
+// not the Host header of the request, the uids must be the same for every hostname or proxy the calendar is loaded by
+const ICS_UID_DOMAIN = "status.mainframe.io"
+
+// Returns the openings as iCalendar (RFC 5545). An opening that is still going on has no end.
+// Params: place (default: space), from, to (default: the last 90 days)
+func OpenStateIcs(dbMgr db.DbManager, group *gin.RouterGroup) {
+	group.GET("", func(c *gin.Context) {
+		place, err := parsePlaceParam(c.Query("place"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		now := time.Now()
+		to, err := parseTimeParam(c.Query("to"), now, time.Local)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		from, err := parseTimeParam(c.Query("from"), to.AddDate(0, 0, -90), time.Local)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if !from.Before(to) || to.Sub(from) > maxIcsRange {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid range, from must be before to and the maximum range is 366 days."})
+			return
+		}
+
+		openStates, err := dbMgr.GetOpenStates(place, from, to)
+		if err != nil {
+			icsLogger.WithError(err).Warn("Can't read the open states.")
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "db not available"})
+			return
+		}
+
+		c.Header("Content-Type", "text/calendar; charset=utf-8")
+		c.Status(http.StatusOK)
+		if err := writeIcs(c.Writer, place, normalizeResults(openStates), now); err != nil {
+			icsLogger.WithError(err).Warn("Can't write the calendar.")
+		}
+	})
+}
+
+// The uid of an opening only depends on the place and the begin, thus clients update the event once it has an end.
+func writeIcs(writer io.Writer, place db.Place, entries []*entry, now time.Time) error {
+	ics := &icsWriter{writer: writer}
+	ics.line("BEGIN:VCALENDAR")
+	ics.line("VERSION:2.0")
+	ics.line("PRODID:-//ktt-ol//status2//EN")
+	ics.line("CALSCALE:GREGORIAN")
+	ics.line("METHOD:PUBLISH")
+	ics.line("X-WR-CALNAME:" + icsText(string(place)+" openings"))
+	for _, e := range entries {
+		ics.line("BEGIN:VEVENT")
+		ics.line(fmt.Sprintf("UID:%s-%d@%s", place, e.begin.Unix(), ICS_UID_DOMAIN))
+		ics.line("DTSTAMP:" + now.UTC().Format(icsTimeFormat))
+		ics.line("DTSTART:" + e.begin.UTC().Format(icsTimeFormat))
+		if e.end != nil {
+			ics.line("DTEND:" + e.end.UTC().Format(icsTimeFormat))
+			// the finished event replaces the open one
+			ics.line("SEQUENCE:1")
+		} else {
+			ics.line("SEQUENCE:0")
+		}
+		ics.line("SUMMARY:" + icsText(string(place)+" open"))
+		ics.line("TRANSP:TRANSPARENT")
+		ics.line("END:VEVENT")
+	}
+	ics.line("END:VCALENDAR")
+
+	return ics.err
+}
+
+type icsWriter struct {
+	writer io.Writer
+	err    error
+}
+
+// writes the content line with CRLF, folded after 75 octets
+func (ics *icsWriter) line(content string) {
+	if ics.err != nil {
+		return
+	}
+
+	var folded strings.Builder
+	lineLength := 0
+	for _, r := range content {
+		size := len(string(r))
+		if lineLength+size > 75 {
+			folded.WriteString("\r\n ")
+			lineLength = 1
+		}
+		folded.WriteRune(r)
+		lineLength += size
+	}
+	folded.WriteString("\r\n")
+
+	_, ics.err = io.WriteString(ics.writer, folded.String())
+}
+
+// escapes a TEXT value
+func icsText(value string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`).Replace(value)
+}
@@ -0,0 +1,91 @@
+package web
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ktt-ol/status2/internal/db"
+	"github.com/ktt-ol/status2/internal/state"
+	"github.com/stretchr/testify/require"
+)
+
+// only the open states, the other methods are not implemented
+type openStatesDb struct {
+	db.DbManager
+	openStates []db.OpenState
+}
+
+func (o *openStatesDb) GetOpenStates(place db.Place, from time.Time, to time.Time) ([]db.OpenState, error) {
+	return o.openStates, nil
+}
+
+func Test_writeIcs(t *testing.T) {
+	entries := []*entry{
+		{mkTestTime(2018, 1, 1, 18, 0), mkTestTime(2018, 1, 1, 20, 30)},
+		{mkTestTime(2018, 1, 2, 18, 0), nil},
+	}
+
+	var buf bytes.Buffer
+	require.Nil(t, writeIcs(&buf, db.PLACE_SPACE, entries, *mkTestTime(2018, 1, 2, 19, 0)))
+	ics := buf.String()
+
+	require.True(t, strings.HasPrefix(ics, "BEGIN:VCALENDAR\r\nVERSION:2.0\r\n"))
+	require.True(t, strings.HasSuffix(ics, "END:VEVENT\r\nEND:VCALENDAR\r\n"))
+	require.Equal(t, 2, strings.Count(ics, "BEGIN:VEVENT\r\n"))
+	require.Contains(t, ics, "UID:space-1514829600@status.mainframe.io\r\n"+
+		"DTSTAMP:20180102T190000Z\r\n"+
+		"DTSTART:20180101T180000Z\r\n"+
+		"DTEND:20180101T203000Z\r\n")
+	// still open
+	require.Contains(t, ics, "UID:space-1514916000@status.mainframe.io\r\n"+
+		"DTSTAMP:20180102T190000Z\r\n"+
+		"DTSTART:20180102T180000Z\r\n"+
+		"SEQUENCE:0\r\n")
+	require.Equal(t, 1, strings.Count(ics, "DTEND"))
+}
+
+func Test_icsLineFolding(t *testing.T) {
+	var buf bytes.Buffer
+	ics := &icsWriter{writer: &buf}
+	ics.line("SUMMARY:" + strings.Repeat("ä", 40))
+	require.Nil(t, ics.err)
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\r\n"), "\r\n")
+	require.Len(t, lines, 2)
+	require.True(t, len(lines[0]) <= 75)
+	require.True(t, strings.HasPrefix(lines[1], " "))
+
+	require.Equal(t, `a\, b\; c\\`, icsText(`a, b; c\`))
+}
+
+func Test_OpenStateIcsRoute(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	api := router.Group("/api")
+	OpenState(state.NewDefaultState(), api.Group("/openState"))
+	OpenStateIcs(&openStatesDb{openStates: []db.OpenState{{Value: state.OPEN, Time: time.Now().Add(-time.Hour)}}},
+		api.Group("/openState.ics"))
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/openState.ics", nil))
+	require.Equal(t, http.StatusOK, recorder.Code)
+	require.Equal(t, "text/calendar; charset=utf-8", recorder.Header().Get("Content-Type"))
+	require.Contains(t, recorder.Body.String(), "BEGIN:VEVENT")
+
+	// the uids don't depend on the hostname
+	request := httptest.NewRequest(http.MethodGet, "/api/openState.ics", nil)
+	request.Host = "192.0.2.1:9000"
+	other := httptest.NewRecorder()
+	router.ServeHTTP(other, request)
+	require.Contains(t, other.Body.String(), "@"+ICS_UID_DOMAIN+"\r\n")
+	require.NotContains(t, other.Body.String(), "192.0.2.1")
+
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/openState.ics?place=mars", nil))
+	require.Equal(t, http.StatusBadRequest, recorder.Code)
+}
@@ -6,6 +6,9 @@ import (
 	"time"
 	"github.com/sirupsen/logrus"
 	"fmt"
+	"net/http"
+	"errors"
+	"strconv"
 )
 
 type entry struct {
@@ -26,27 +29,107 @@ var openStatsLogger = logrus.WithField("where", "OpenStatistics")
 
 const dayInSeconds int64 = 60 * 60 * 24;
 
+// Returns the opening slots per year and day.
+// Params: place (default: space), from and to (years, inclusive, default: all), tz (IANA time zone, default: local)
+// The slot offsets are in wall clock seconds of the time zone, thus a slot on a DST day is at its local time. The
+// durations are the real elapsed seconds, e.g. 2 hours from 1:00 to 4:00 on the day the clock jumps forward.
 func OpenStatistics(dbMgr db.DbManager, group *gin.RouterGroup) {
 	group.GET("", func(c *gin.Context) {
-		entries := normalizeResults(dbMgr.GetAllSpaceOpenStates())
+		location, err := parseLocationParam(c.Query("tz"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		place, err := parsePlaceParam(c.Query("place"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		now := time.Now().In(location)
+		from, err := parseYearParam(c.Query("from"), time.Unix(0, 0), location)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		to, err := parseYearParam(c.Query("to"), now, location)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if c.Query("to") != "" {
+			// the whole year
+			to = to.AddDate(1, 0, 0)
+		}
+		if now.Before(to) {
+			to = now
+		}
+		if !from.Before(to) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid range, from must be before to."})
+			return
+		}
+
+		openStates, err := dbMgr.GetOpenStates(place, from, to)
+		if err != nil {
+			openStatsLogger.WithError(err).Warn("Can't read the open states.")
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "db not available"})
+			return
+		}
+		entries := clipEntries(normalizeResults(openStates), from, to, location)
 		if len(entries) == 0 {
 			c.JSON(200, nil)
 			return
 		}
 
-		slots := buildSlots(entries, time.Now().Year())
+		slots := buildSlots(entries, now.Year())
 		fillNilSlots(slots)
 		c.JSON(200, slots)
 	})
 }
 
+// a year (e.g. 2018) is the start of the year in the given location, empty returns the fallback
+func parseYearParam(value string, fallback time.Time, location *time.Location) (time.Time, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	year, err := strconv.Atoi(value)
+	if err != nil || year < 1970 || year > 9999 {
+		return time.Time{}, errors.New("Invalid year: " + value)
+	}
+
+	return time.Date(year, 1, 1, 0, 0, 0, 0, location), nil
+}
+
+// Cuts the entries to [from, to) and converts them to the location. An entry without end is still open, it ends at to.
+func clipEntries(entries []*entry, from time.Time, to time.Time, location *time.Location) []*entry {
+	result := make([]*entry, 0, len(entries))
+	for _, e := range entries {
+		begin := *e.begin
+		end := to
+		if e.end != nil {
+			end = *e.end
+		}
+		if !end.After(from) || !begin.Before(to) {
+			continue
+		}
+		if begin.Before(from) {
+			begin = from
+		}
+		if end.After(to) {
+			end = to
+		}
+
+		begin = begin.In(location)
+		end = end.In(location)
+		result = append(result, &entry{begin: &begin, end: &end})
+	}
+
+	return result
+}
+
 type tmp struct {
 	// e.g. 2017, 2018
 	currentYear int
-	// unix timestamp in sec.
-	currentYearTs int64
-	// stores open times for the next year
-	nextYearCarry [][2]int64
 
 	// the final data structure for a single year
 	entriesForCurrentYear [ /*days in year*/ ][ /*a day*/ ][ /*begin delta, duration*/ 2]int64
@@ -54,51 +137,40 @@ type tmp struct {
 	statsResult []*yearEntries
 }
 
+// Adds the slot to the day of begin, the rest is added to the following days. Begin and end must be in the same
+// location, the day is computed in that location. The offset is the wall clock time of begin, the duration is the
+// elapsed time.
 func (note *tmp) addSlot(begin *time.Time, end *time.Time) {
-
 	year, month, day := begin.Date()
-
-	// the day starts with 1, the index with 0
-	beginDayIndex := begin.YearDay() - 1
-	endDayIndex := end.YearDay() - 1
-
-	if year > note.currentYear {
+	if year != note.currentYear {
 		note.newYear(year)
 	}
 
-	beginDayTs := note.currentYearTs + int64(beginDayIndex)*dayInSeconds
-
-	if beginDayIndex == endDayIndex {
-		// the space was closed on the same day
-		dayEntry := [2]int64{
-			begin.Unix() - beginDayTs, // offset from the day slot
-			end.Unix() - begin.Unix(), // duration
-		}
-
-		if year > note.currentYear {
-			note.nextYearCarry = append(note.nextYearCarry, dayEntry)
-		} else {
-			note.entriesForCurrentYear[beginDayIndex] = append(note.entriesForCurrentYear[beginDayIndex], dayEntry)
-		}
+	// the day starts with 1, the index with 0
+	dayIndex := begin.YearDay() - 1
+	nextDayStart := time.Date(year, month, day+1, 0, 0, 0, 0, begin.Location())
 
-		return
+	slotEnd := nextDayStart
+	if end.Before(nextDayStart) {
+		slotEnd = *end
 	}
 
-	// the end is at the following day...
-	// fill up the current day
-	currentDayEnd := time.Date(year, month, day, 23, 59, 59, 0, begin.Location())
 	dayEntry := [2]int64{
-		begin.Unix() - beginDayTs,           // offset from the day slot
-		currentDayEnd.Unix() - begin.Unix(), // duration
+		wallClockSeconds(begin), // offset from the day slot
+		int64(slotEnd.Sub(*begin).Seconds()),
 	}
-	if year > note.currentYear {
-		note.entriesForCurrentYear[beginDayIndex] = append(note.entriesForCurrentYear[beginDayIndex], dayEntry)
-	} else {
-		note.entriesForCurrentYear[beginDayIndex] = append(note.entriesForCurrentYear[beginDayIndex], dayEntry)
+	note.entriesForCurrentYear[dayIndex] = append(note.entriesForCurrentYear[dayIndex], dayEntry)
+
+	if end.After(nextDayStart) {
+		// the end is at the following day
+		note.addSlot(&nextDayStart, end)
 	}
+}
 
-	nextDayStart := currentDayEnd.Add(time.Duration(1) * time.Second)
-	note.addSlot(&nextDayStart, end)
+// the seconds since the local midnight as shown on a clock, ignores DST changes
+func wallClockSeconds(ts *time.Time) int64 {
+	hour, min, sec := ts.Clock()
+	return int64(hour*3600 + min*60 + sec)
 }
 
 func (note *tmp) newYear(beginYear int) {
@@ -108,17 +180,11 @@ func (note *tmp) newYear(beginYear int) {
 
 	note.currentYear = beginYear
 	note.entriesForCurrentYear = makeYearStructure(beginYear)
-	note.currentYearTs = time.Date(note.currentYear, 1, 1, 0, 0, 0, 0, time.UTC).Unix()
-
-	// add the last year carry
-	for i := range note.nextYearCarry {
-		note.entriesForCurrentYear[0] = append(note.entriesForCurrentYear[0], note.nextYearCarry[i])
-	}
 }
 
-
+// The entries must be ordered and in the location of the statistics.
 func buildSlots(entries []*entry, yearNow int) []*yearEntries {
-	note := &tmp{nextYearCarry: make([][2]int64, 0), statsResult: make([]*yearEntries, 0, 10)}
+	note := &tmp{statsResult: make([]*yearEntries, 0, 10)}
 
 	for i := range entries {
 		entry := entries[i]
@@ -99,3 +99,90 @@ func mkTestTime(year int, month time.Month, day, hour, min int) *time.Time {
 	date := time.Date(year, month, day, hour, min, 0, 0, time.UTC)
 	return &date
 }
+
+func Test_buildSlots_DST(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	require.Nil(t, err)
+
+	testEntries := clipEntries([]*entry{
+		// the clock jumps from 2:00 to 3:00
+		{mkTestTime(2018, 3, 25, 0, 0), mkTestTime(2018, 3, 25, 2, 0)},
+		// 23:00 local time at the last day before the clock change
+		{mkTestTime(2018, 10, 27, 21, 0), mkTestTime(2018, 10, 27, 22, 0)},
+		// the clock jumps from 3:00 back to 2:00
+		{mkTestTime(2018, 10, 27, 23, 0), mkTestTime(2018, 10, 28, 3, 0)},
+	}, time.Unix(0, 0), *mkTestTime(2019, 1, 1, 0, 0), berlin)
+
+	slots := buildSlots(testEntries, 2019)
+	require.Equal(t, 1, len(slots))
+	y2018 := slots[0].Entries
+
+	// 1:00 to 4:00 local time, but only 2 hours elapsed
+	march25 := time.Date(2018, 3, 25, 0, 0, 0, 0, berlin).YearDay() - 1
+	require.Equal(t, [][2]int64{{3600, 2 * 3600}}, y2018[march25])
+
+	// 1:00 (CEST) to 4:00 (CET) local time, 4 hours elapsed with the repeated hour
+	october28 := time.Date(2018, 10, 28, 0, 0, 0, 0, berlin).YearDay() - 1
+	require.Equal(t, [][2]int64{{3600, 4 * 3600}}, y2018[october28])
+	require.Equal(t, [][2]int64{{23 * 3600, 3600}}, y2018[october28-1])
+
+	validateEntries(t, slots[0])
+}
+
+func Test_buildSlots_repeatedHour(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	require.Nil(t, err)
+
+	// from the first 2:30 (CEST) to the repeated 2:15 (CET), the end is before the begin on the clock
+	testEntries := clipEntries([]*entry{
+		{mkTestTime(2018, 10, 28, 0, 30), mkTestTime(2018, 10, 28, 1, 15)},
+	}, time.Unix(0, 0), *mkTestTime(2019, 1, 1, 0, 0), berlin)
+
+	slots := buildSlots(testEntries, 2019)
+	october28 := time.Date(2018, 10, 28, 0, 0, 0, 0, berlin).YearDay() - 1
+	require.Equal(t, [][2]int64{{2*3600 + 30*60, 45 * 60}}, slots[0].Entries[october28])
+}
+
+func Test_buildSlots_NewYear(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	require.Nil(t, err)
+
+	// 2017-12-31 23:00 to 2018-01-01 01:30 in Berlin, in UTC everything is in 2017
+	testEntries := clipEntries([]*entry{
+		{mkTestTime(2017, 12, 31, 22, 0), mkTestTime(2018, 1, 1, 0, 30)},
+	}, time.Unix(0, 0), *mkTestTime(2019, 1, 1, 0, 0), berlin)
+
+	slots := buildSlots(testEntries, 2019)
+	require.Equal(t, 2, len(slots))
+	require.Equal(t, 2017, slots[0].Year)
+	require.Equal(t, [][2]int64{{23 * 3600, 3600}}, slots[0].Entries[364])
+	require.Equal(t, 2018, slots[1].Year)
+	require.Equal(t, [][2]int64{{0, 5400}}, slots[1].Entries[0])
+
+	// only 2018
+	testEntries = clipEntries([]*entry{
+		{mkTestTime(2017, 12, 31, 22, 0), mkTestTime(2018, 1, 1, 0, 30)},
+		{mkTestTime(2018, 12, 31, 22, 0), nil},
+	}, time.Date(2018, 1, 1, 0, 0, 0, 0, berlin), time.Date(2019, 1, 1, 0, 0, 0, 0, berlin), berlin)
+	require.Equal(t, 2, len(testEntries))
+
+	slots = buildSlots(testEntries, 2019)
+	require.Equal(t, 1, len(slots))
+	require.Equal(t, 2018, slots[0].Year)
+	require.Equal(t, [][2]int64{{0, 5400}}, slots[0].Entries[0])
+	require.Equal(t, [][2]int64{{23 * 3600, 3600}}, slots[0].Entries[364])
+}
+
+func Test_parseYearParam(t *testing.T) {
+	fallback := time.Unix(1000, 0)
+	year, err := parseYearParam("", fallback, time.UTC)
+	require.Nil(t, err)
+	require.Equal(t, fallback, year)
+
+	year, err = parseYearParam("2018", fallback, time.UTC)
+	require.Nil(t, err)
+	require.Equal(t, time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC), year)
+
+	_, err = parseYearParam("18", fallback, time.UTC)
+	require.NotNil(t, err)
+}
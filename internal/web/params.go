@@ -0,0 +1,55 @@
+package web
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/ktt-ol/status2/internal/db"
+)
+
+// Parses a time parameter: a unix timestamp in seconds, RFC 3339 or a date (YYYY-MM-DD, in the given location).
+// An empty value returns the fallback.
+func parseTimeParam(value string, fallback time.Time, location *time.Location) (time.Time, error) {
+	if value == "" {
+		return fallback, nil
+	}
+
+	if unix, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(unix, 0), nil
+	}
+	if ts, err := time.Parse(time.RFC3339, value); err == nil {
+		return ts, nil
+	}
+	if ts, err := time.ParseInLocation("2006-01-02", value, location); err == nil {
+		return ts, nil
+	}
+
+	return time.Time{}, errors.New("Invalid time: " + value)
+}
+
+// Parses an IANA time zone (e.g. Europe/Berlin), empty is the local time zone.
+func parseLocationParam(value string) (*time.Location, error) {
+	if value == "" {
+		return time.Local, nil
+	}
+	location, err := time.LoadLocation(value)
+	if err != nil {
+		return nil, errors.New("Invalid time zone: " + value)
+	}
+
+	return location, nil
+}
+
+// Parses a place stored in the db, empty is the space.
+func parsePlaceParam(value string) (db.Place, error) {
+	if value == "" {
+		return db.PLACE_SPACE, nil
+	}
+	place := db.Place(value)
+	if !db.IsValidPlace(place) {
+		return "", errors.New("Invalid place: " + value)
+	}
+
+	return place, nil
+}
@@ -0,0 +1,75 @@
+package web
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ktt-ol/status2/internal/db"
+	"github.com/ktt-ol/status2/internal/state"
+	"github.com/sirupsen/logrus"
+)
+
+var powerLogger = logrus.WithField("where", "Power")
+
+// limits the amount of samples for a single request
+const maxPowerRange = 31 * 24 * time.Hour
+
+// Returns the stored power samples as amCharts data provider, one entry per time with the average and max of every
+// meter, e.g. [{"date": 1577872800000, "front": 200, "front_max": 300, "back": 50, "back_max": 50}]
+// The default range is the last 24 hours.
+func Power(dbMgr db.DbManager, group *gin.RouterGroup) {
+	group.GET("", func(c *gin.Context) {
+		now := time.Now()
+		to, err := parseTimeParam(c.Query("to"), now, time.Local)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		from, err := parseTimeParam(c.Query("from"), to.Add(-24*time.Hour), time.Local)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if !from.Before(to) || to.Sub(from) > maxPowerRange {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid range, from must be before to and the maximum range is 31 days."})
+			return
+		}
+
+		var meter state.PowerMeter
+		if c.Query("meter") != "" {
+			meter, err = state.ParsePowerMeter(c.Query("meter"))
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+
+		samples, err := dbMgr.GetPowerSamples(meter, from, to)
+		if err != nil {
+			powerLogger.WithError(err).Warn("Can't read the power samples.")
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "db not available"})
+			return
+		}
+
+		c.JSON(http.StatusOK, toPowerChartData(samples))
+	})
+}
+
+// merges the samples with the same time into one entry
+func toPowerChartData(samples []db.PowerSample) []map[string]interface{} {
+	chartData := make([]map[string]interface{}, 0)
+	var current map[string]interface{}
+	var currentTs time.Time
+	for _, sample := range samples {
+		if current == nil || !sample.Timestamp.Equal(currentTs) {
+			currentTs = sample.Timestamp
+			current = map[string]interface{}{"date": sample.Timestamp.UnixNano() / int64(time.Millisecond)}
+			chartData = append(chartData, current)
+		}
+		current[string(sample.Meter)] = sample.Avg
+		current[string(sample.Meter)+"_max"] = sample.Max
+	}
+
+	return chartData
+}
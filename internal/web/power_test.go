@@ -0,0 +1,51 @@
+package web
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ktt-ol/status2/internal/db"
+	"github.com/ktt-ol/status2/internal/state"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_toPowerChartData(t *testing.T) {
+	ts := time.Unix(1577872800, 0)
+	samples := []db.PowerSample{
+		{Meter: state.POWER_METER_BACK, Timestamp: ts, Avg: 50, Max: 60},
+		{Meter: state.POWER_METER_FRONT, Timestamp: ts, Avg: 200, Max: 300},
+		{Meter: state.POWER_METER_FRONT, Timestamp: ts.Add(time.Minute), Avg: 10, Max: 10},
+	}
+
+	data := toPowerChartData(samples)
+	require.Len(t, data, 2)
+	require.Equal(t, map[string]interface{}{"date": int64(1577872800000), "back": 50.0, "back_max": 60.0,
+		"front": 200.0, "front_max": 300.0}, data[0])
+	require.Equal(t, map[string]interface{}{"date": int64(1577872860000), "front": 10.0, "front_max": 10.0}, data[1])
+
+	require.Len(t, toPowerChartData(nil), 0)
+}
+
+func Test_parseTimeParam(t *testing.T) {
+	fallback := time.Unix(42, 0)
+	ts, err := parseTimeParam("", fallback, time.UTC)
+	require.Nil(t, err)
+	require.Equal(t, fallback, ts)
+
+	ts, err = parseTimeParam("1577872800", fallback, time.UTC)
+	require.Nil(t, err)
+	require.Equal(t, int64(1577872800), ts.Unix())
+
+	ts, err = parseTimeParam("2020-01-01T10:00:00+01:00", fallback, time.UTC)
+	require.Nil(t, err)
+	require.Equal(t, int64(1577869200), ts.Unix())
+
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	require.Nil(t, err)
+	ts, err = parseTimeParam("2020-07-01", fallback, berlin)
+	require.Nil(t, err)
+	require.Equal(t, time.Date(2020, 6, 30, 22, 0, 0, 0, time.UTC).Unix(), ts.Unix())
+
+	_, err = parseTimeParam("yesterday", fallback, time.UTC)
+	require.NotNil(t, err)
+}
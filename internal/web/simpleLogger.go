@@ -4,6 +4,7 @@ import (
 	"time"
 	"github.com/gin-gonic/gin"
 	"fmt"
+	"net/url"
 )
 
 func SimpleNoTimeLogging() gin.HandlerFunc {
@@ -25,7 +26,15 @@ func SimpleNoTimeLogging() gin.HandlerFunc {
 			latency,
 			c.Request.Method,
 			c.Request.URL.Path,
-			c.Request.URL.RawQuery,
+			redactedQuery(c.Request.URL.Query()),
 		)
 	}
 }
+
+// the query without the values of secrets, e.g. the switch password
+func redactedQuery(query url.Values) string {
+	if _, ok := query["password"]; ok {
+		query.Set("password", "***")
+	}
+	return query.Encode()
+}
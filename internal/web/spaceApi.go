@@ -0,0 +1,239 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ktt-ol/status2/internal/state"
+)
+
+type spaceApiVersion string
+
+const (
+	SPACEAPI_V14 spaceApiVersion = "14"
+	SPACEAPI_V15 spaceApiVersion = "15"
+	// valid for v14 and v15, the default
+	SPACEAPI_COMPAT spaceApiVersion = "compat"
+)
+
+func parseSpaceApiVersion(value string) (spaceApiVersion, error) {
+	switch version := spaceApiVersion(value); version {
+	case "":
+		return SPACEAPI_COMPAT, nil
+	case SPACEAPI_V14, SPACEAPI_V15, SPACEAPI_COMPAT:
+		return version, nil
+	}
+
+	return "", errors.New("Invalid version, must be 14, 15 or compat: " + value)
+}
+
+type spaceApi struct {
+	// v14 only
+	Api string `json:"api,omitempty"`
+	// v15 only
+	ApiCompatibility []string         `json:"api_compatibility,omitempty"`
+	Space            string           `json:"space"`
+	Logo             string           `json:"logo"`
+	Url              string           `json:"url"`
+	Location         spaceApiLocation `json:"location"`
+	Contact          spaceApiContact  `json:"contact"`
+	// v14 only
+	IssueReportChannels []string                 `json:"issue_report_channels,omitempty"`
+	State               spaceApiState            `json:"state"`
+	Sensors             spaceApiSensors          `json:"sensors"`
+	Feeds               spaceApiFeeds            `json:"feeds"`
+	Projects            []string                 `json:"projects"`
+	ExtOpenForecast     []map[string]interface{} `json:"ext_open_forecast,omitempty"`
+}
+
+type spaceApiLocation struct {
+	Address string  `json:"address"`
+	Lat     float64 `json:"lat"`
+	Lon     float64 `json:"lon"`
+}
+
+type spaceApiContact struct {
+	Email     string `json:"email"`
+	Ml        string `json:"ml"`
+	IssueMail string `json:"issue_mail"`
+}
+
+type spaceApiState struct {
+	Open       bool         `json:"open"`
+	Lastchange int64        `json:"lastchange,omitempty"`
+	Message    string       `json:"message"`
+	Icon       spaceApiIcon `json:"icon"`
+	// the other places
+	ExtRadstelle   spaceApiPlaceState `json:"ext_radstelle"`
+	ExtLab3d       spaceApiPlaceState `json:"ext_lab3d"`
+	ExtMachining   spaceApiPlaceState `json:"ext_machining"`
+	ExtWoodworking spaceApiPlaceState `json:"ext_woodworking"`
+}
+
+type spaceApiIcon struct {
+	Open   string `json:"open"`
+	Closed string `json:"closed"`
+}
+
+type spaceApiPlaceState struct {
+	Open       bool   `json:"open"`
+	Lastchange int64  `json:"lastchange,omitempty"`
+	Message    string `json:"message,omitempty"`
+}
+
+type spaceApiSensors struct {
+	DoorLocked         []spaceApiDoorLocked    `json:"door_locked,omitempty"`
+	PowerConsumption   []spaceApiPower         `json:"power_consumption"`
+	NetworkConnections []spaceApiNetwork       `json:"network_connections"`
+	PeopleNowPresent   []spaceApiPeoplePresent `json:"people_now_present"`
+}
+
+type spaceApiDoorLocked struct {
+	Value    bool   `json:"value"`
+	Location string `json:"location"`
+}
+
+type spaceApiPower struct {
+	Value       float64 `json:"value"`
+	Unit        string  `json:"unit"`
+	Location    string  `json:"location"`
+	Name        string  `json:"name"`
+	Description string  `json:"description"`
+}
+
+type spaceApiNetwork struct {
+	Value       int    `json:"value"`
+	Name        string `json:"name"`
+	Location    string `json:"location,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+type spaceApiPeoplePresent struct {
+	Value int      `json:"value"`
+	Names []string `json:"names,omitempty"`
+}
+
+type spaceApiFeeds struct {
+	Calendar spaceApiFeed `json:"calendar"`
+}
+
+type spaceApiFeed struct {
+	Type string `json:"type"`
+	Url  string `json:"url"`
+}
+
+// The forecast and the notes are optional, the message of a note replaces the default message.
+func buildSpaceApi(st *state.State, version spaceApiVersion, forecast []map[string]interface{}, notes *stateNotes,
+	nowInSeconds int64) spaceApi {
+	spaceOpen := st.Open.Space.Value.IsPublicOpen()
+	message := notes.message(state.PLACE_SPACE)
+	if message == "" {
+		message = "Close!"
+		if spaceOpen {
+			message = "Open!"
+		}
+	}
+	internetStatus := 0
+	if st.Mqtt.SpaceBrokerOnline {
+		internetStatus = 1
+	}
+
+	data := spaceApi{
+		Space: "Mainframe",
+		Logo:  "https://status.mainframe.io/assets/images/mainframe.png",
+		Url:   "https://mainframe.io/",
+		Location: spaceApiLocation{
+			Address: "Bahnhofsplatz 10, 26122 Oldenburg, Germany",
+			Lat:     53.14402,
+			Lon:     8.21988,
+		},
+		Contact: spaceApiContact{
+			Email:     "vorstand@kreativitaet-trifft-technik.de",
+			Ml:        "https://mailman.ktt-ol.de/postorius/lists/diskussion.lists.ktt-ol.de/",
+			IssueMail: "hc@kreativitaet-trifft-technik.de",
+		},
+		State: spaceApiState{
+			Open:       spaceOpen,
+			Lastchange: st.Open.Space.Timestamp,
+			Message:    message,
+			Icon: spaceApiIcon{
+				Open:   "https://www.kreativitaet-trifft-technik.de/media/img/mainframe-open.svg",
+				Closed: "https://www.kreativitaet-trifft-technik.de/media/img/mainframe-closed.svg",
+			},
+			ExtRadstelle:   placeState(st.Open.Radstelle, notes.message(state.PLACE_RADSTELLE)),
+			ExtLab3d:       placeState(st.Open.Lab3d, notes.message(state.PLACE_LAB3D)),
+			ExtMachining:   placeState(st.Open.Machining, notes.message(state.PLACE_MACHINING)),
+			ExtWoodworking: placeState(st.Open.Woodworking, notes.message(state.PLACE_WOODWORKING)),
+		},
+		Sensors: spaceApiSensors{
+			PowerConsumption: []spaceApiPower{
+				powerSensor("front", st.PowerUsage.Front, nowInSeconds),
+				powerSensor("back", st.PowerUsage.Back, nowInSeconds),
+				powerSensor("machining", st.PowerUsage.Machining, nowInSeconds),
+			},
+			NetworkConnections: []spaceApiNetwork{
+				{Value: int(st.SpaceDevices.DeviceCount), Name: "deviceCount", Location: "Inside"},
+				{
+					Value:       internetStatus,
+					Name:        "internetStatus",
+					Description: "0: no internet connection, 1: everything is fine",
+				},
+			},
+			PeopleNowPresent: getPeopleSensor(st.SpaceDevices),
+		},
+		Feeds: spaceApiFeeds{
+			Calendar: spaceApiFeed{
+				Type: "application/calendar",
+				Url:  "https://www.kreativitaet-trifft-technik.de/calendar/ical/markusframer@gmail.com/public/basic.ics",
+			},
+		},
+		Projects:        []string{"https://github.com/ktt-ol/"},
+		ExtOpenForecast: forecast,
+	}
+
+	if locked, ok := parseBoltContact(st.Backdoor); ok {
+		data.Sensors.DoorLocked = []spaceApiDoorLocked{{Value: locked, Location: "Backdoor"}}
+	}
+
+	switch version {
+	case SPACEAPI_V14:
+		data.Api = "0.14"
+		data.IssueReportChannels = []string{"issue_mail"}
+	case SPACEAPI_V15:
+		data.ApiCompatibility = []string{"15"}
+	default:
+		data.Api = "0.14"
+		data.ApiCompatibility = []string{"14", "15"}
+		data.IssueReportChannels = []string{"issue_mail"}
+	}
+
+	return data
+}
+
+func placeState(value *state.OpenValueTs, message string) spaceApiPlaceState {
+	return spaceApiPlaceState{Open: value.Value.IsPublicOpen(), Lastchange: value.Timestamp, Message: message}
+}
+
+// the power values in the state are always in watt
+func powerSensor(name string, value *state.PowerValueTs, nowInSeconds int64) spaceApiPower {
+	return spaceApiPower{
+		Value:       value.Value,
+		Unit:        string(value.Unit),
+		Location:    "Hackspace, " + name,
+		Name:        "current consumption " + name,
+		Description: fmt.Sprintf("Value changed %d sec. ago.", nowInSeconds-value.Timestamp),
+	}
+}
+
+// The bolt contact is closed if the door is locked. Returns false for ok if the payload is unknown (or not set yet).
+func parseBoltContact(payload string) (locked bool, ok bool) {
+	switch strings.ToLower(strings.TrimSpace(payload)) {
+	case "closed", "locked", "1", "true":
+		return true, true
+	case "open", "unlocked", "0", "false":
+		return false, true
+	}
+
+	return false, false
+}
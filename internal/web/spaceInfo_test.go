@@ -0,0 +1,98 @@
+package web
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ktt-ol/spaceDevices/pkg/structs"
+	"github.com/ktt-ol/status2/internal/state"
+	"github.com/stretchr/testify/require"
+	"github.com/xeipuuv/gojsonschema"
+)
+
+func testSpaceApiState() *state.State {
+	st := state.NewDefaultState()
+	st.Open.Space = &state.OpenValueTs{Value: state.OPEN, Timestamp: 1500000000}
+	st.Open.Lab3d = &state.OpenValueTs{Value: state.OPEN_PLUS, Timestamp: 1500000100}
+	st.SpaceDevices.PeopleCount = 2
+	st.SpaceDevices.People = []structs.Person{{Name: "alice"}, {Name: "bob"}}
+	st.PowerUsage.Front = &state.PowerValueTs{Value: 123.4, Unit: state.WATT, Timestamp: 1500000000}
+	st.Backdoor = "closed"
+	return st
+}
+
+func requireValidSpaceApi(t *testing.T, schema string, data spaceApi) {
+	path, err := filepath.Abs(filepath.Join("testdata", "spaceapi", schema))
+	require.Nil(t, err)
+
+	result, err := gojsonschema.Validate(gojsonschema.NewReferenceLoader("file://"+filepath.ToSlash(path)),
+		gojsonschema.NewGoLoader(data))
+	require.Nil(t, err)
+	errors := make([]string, len(result.Errors()))
+	for i, e := range result.Errors() {
+		errors[i] = e.String()
+	}
+	require.True(t, result.Valid(), "%s: %s", schema, strings.Join(errors, ", "))
+}
+
+func Test_buildSpaceApi_schemas(t *testing.T) {
+	forecast := []map[string]interface{}{{"start": int64(1500003600), "probability": 0.5}}
+	for _, st := range []*state.State{state.NewDefaultState(), testSpaceApiState()} {
+		requireValidSpaceApi(t, "14.json", buildSpaceApi(st, SPACEAPI_V14, forecast, nil, 1500000000))
+		requireValidSpaceApi(t, "15.json", buildSpaceApi(st, SPACEAPI_V15, forecast, nil, 1500000000))
+		requireValidSpaceApi(t, "14.json", buildSpaceApi(st, SPACEAPI_COMPAT, nil, nil, 1500000000))
+		requireValidSpaceApi(t, "15.json", buildSpaceApi(st, SPACEAPI_COMPAT, nil, nil, 1500000000))
+	}
+}
+
+func Test_buildSpaceApi(t *testing.T) {
+	data := buildSpaceApi(testSpaceApiState(), SPACEAPI_V15, nil, nil, 1500000010)
+
+	require.Equal(t, "", data.Api)
+	require.Equal(t, []string{"15"}, data.ApiCompatibility)
+	require.Nil(t, data.IssueReportChannels)
+	require.True(t, data.State.Open)
+	require.Equal(t, int64(1500000000), data.State.Lastchange)
+	require.Equal(t, spaceApiPlaceState{Open: true, Lastchange: 1500000100}, data.State.ExtLab3d)
+	require.Equal(t, spaceApiPlaceState{Open: false}, data.State.ExtRadstelle)
+	require.Equal(t, []spaceApiDoorLocked{{Value: true, Location: "Backdoor"}}, data.Sensors.DoorLocked)
+	require.Len(t, data.Sensors.PowerConsumption, 3)
+	require.Equal(t, 123.4, data.Sensors.PowerConsumption[0].Value)
+	require.Equal(t, "W", data.Sensors.PowerConsumption[0].Unit)
+	require.Equal(t, "Value changed 10 sec. ago.", data.Sensors.PowerConsumption[0].Description)
+	require.Equal(t, []spaceApiPeoplePresent{{Value: 2, Names: []string{"alice", "bob"}}}, data.Sensors.PeopleNowPresent)
+
+	compat := buildSpaceApi(state.NewDefaultState(), SPACEAPI_COMPAT, nil, nil, 0)
+	require.Equal(t, "0.14", compat.Api)
+	require.Equal(t, []string{"14", "15"}, compat.ApiCompatibility)
+	require.Nil(t, compat.Sensors.DoorLocked)
+}
+
+func Test_parseBoltContact(t *testing.T) {
+	locked, ok := parseBoltContact("closed")
+	require.True(t, ok)
+	require.True(t, locked)
+
+	locked, ok = parseBoltContact(" Open\n")
+	require.True(t, ok)
+	require.False(t, locked)
+
+	_, ok = parseBoltContact("")
+	require.False(t, ok)
+	_, ok = parseBoltContact("something")
+	require.False(t, ok)
+}
+
+func Test_parseSpaceApiVersion(t *testing.T) {
+	version, err := parseSpaceApiVersion("")
+	require.Nil(t, err)
+	require.Equal(t, SPACEAPI_COMPAT, version)
+
+	version, err = parseSpaceApiVersion("14")
+	require.Nil(t, err)
+	require.Equal(t, SPACEAPI_V14, version)
+
+	_, err = parseSpaceApiVersion("13")
+	require.NotNil(t, err)
+}
@@ -0,0 +1,346 @@
+package web
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ktt-ol/status2/internal/events"
+	"github.com/ktt-ol/status2/internal/state"
+	"github.com/sirupsen/logrus"
+)
+
+var stateApiLogger = logrus.WithField("where", "StateApi")
+
+const (
+	MAX_STATE_MESSAGE_LENGTH = 200
+	// until times restored after a restart are not handled before the current states arrived via mqtt
+	RESTORED_UNTIL_MIN_DELAY = 30 * time.Second
+)
+
+// *mqtt.MqttManager
+type openStatePublisher interface {
+	PublishOpenState(place state.Place, value state.OpenValue) error
+}
+
+type stateRequest struct {
+	State string `json:"state"`
+	// optional, shown in the SpaceAPI while the state lasts
+	Message string `json:"message"`
+	// optional, the place is closed at this time
+	Until *time.Time `json:"until"`
+}
+
+// The message and until time of a state that was set via the api. A note belongs to the state value and is dropped as
+// soon as the place has another state.
+type stateNote struct {
+	Value     state.OpenValue `json:"value"`
+	Message   string          `json:"message,omitempty"`
+	Until     *time.Time      `json:"until,omitempty"`
+	ChangedBy string          `json:"changedBy"`
+	timer     *time.Timer
+}
+
+type stateNotes struct {
+	lock  sync.Mutex
+	notes map[state.Place]*stateNote
+	// optional, the notes are written to this file on every change, thus the until times survive a restart
+	filename string
+}
+
+func newStateNotes(filename string) *stateNotes {
+	n := &stateNotes{notes: make(map[state.Place]*stateNote), filename: filename}
+	if filename == "" {
+		return n
+	}
+
+	notes, err := loadStateNotes(filename)
+	if err != nil {
+		stateApiLogger.WithError(err).Error("Can't read the state notes file.")
+		return n
+	}
+	n.notes = notes
+	return n
+}
+
+func (n *stateNotes) get(place state.Place) *stateNote {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	return n.notes[place]
+}
+
+// replaces the note of the place, nil only removes it
+func (n *stateNotes) set(place state.Place, note *stateNote) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	if old := n.notes[place]; old != nil && old.timer != nil {
+		old.timer.Stop()
+	}
+	if note == nil {
+		delete(n.notes, place)
+	} else {
+		n.notes[place] = note
+	}
+	n.save()
+}
+
+// the notes with an until time
+func (n *stateNotes) withUntil() map[state.Place]*stateNote {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	result := make(map[state.Place]*stateNote)
+	for place, note := range n.notes {
+		if note.Until != nil {
+			result[place] = note
+		}
+	}
+	return result
+}
+
+// the lock must be held
+func (n *stateNotes) save() {
+	if n.filename == "" {
+		return
+	}
+	if err := writeStateNotes(n.filename, n.notes); err != nil {
+		stateApiLogger.WithError(err).Error("Can't write the state notes file.")
+	}
+}
+
+// removes the note if it doesn't belong to the current value of the place
+func (n *stateNotes) update(place state.Place, current state.OpenValue) {
+	if note := n.get(place); note != nil && note.Value != current {
+		n.set(place, nil)
+	}
+}
+
+// the message of the current state, if any
+func (n *stateNotes) message(place state.Place) string {
+	if n == nil {
+		return ""
+	}
+	if note := n.get(place); note != nil {
+		return note.Message
+	}
+	return ""
+}
+
+type stateApi struct {
+	// token -> user
+	users     map[string]string
+	appState  *state.State
+	publisher openStatePublisher
+	notes     *stateNotes
+	audit     *auditLog
+}
+
+// Changes the state of a place, authenticated with "Authorization: Bearer <token>". The tokens are configured per user.
+// Body: {"state": "open", "message": "optional", "until": "optional RFC 3339 time, the place is closed then"}
+func StateApi(apiTokens map[string]string, appState *state.State, ev events.EventManager, publisher openStatePublisher,
+	notes *stateNotes, audit *auditLog, group *gin.RouterGroup) {
+	api := &stateApi{users: make(map[string]string), appState: appState, publisher: publisher, notes: notes, audit: audit}
+	for user, token := range apiTokens {
+		if token == "" {
+			stateApiLogger.WithField("user", user).Warn("Ignoring the empty api token.")
+			continue
+		}
+		api.users[token] = user
+	}
+	if len(api.users) == 0 {
+		logger.Info("/api/state is disabled, because no api token is set.")
+		return
+	}
+
+	// the until times from before the restart
+	minUntil := time.Now().Add(RESTORED_UNTIL_MIN_DELAY)
+	for place, note := range notes.withUntil() {
+		until := *note.Until
+		if until.Before(minUntil) {
+			until = minUntil
+		}
+		stateApiLogger.WithField("place", place).WithField("until", note.Until).Info("Restoring the until time.")
+		api.closeAt(place, note, until)
+	}
+
+	for _, place := range state.AllPlaces {
+		place := place
+		if event, err := place.OpenStateEvent(); err == nil {
+			ev.On(event, func(topic events.EventName) {
+				if current, err := appState.Open.ForPlace(place); err == nil {
+					notes.update(place, current.Value)
+				}
+			})
+		}
+	}
+
+	group.POST("/:place", api.handleChange)
+}
+
+func (api *stateApi) handleChange(c *gin.Context) {
+	user, ok := api.authenticate(c.GetHeader("Authorization"))
+	if !ok {
+		stateApiLogger.WithField("remoteIp", c.ClientIP()).Warn("Invalid api token.")
+		c.Header("WWW-Authenticate", "Bearer")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token."})
+		return
+	}
+
+	place, err := state.ParsePlace(c.Param("place"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var request stateRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid json: " + err.Error()})
+		return
+	}
+	value, err := validateStateRequest(request, time.Now())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	previous, _ := api.appState.Open.ForPlace(place)
+	entry := auditEntry{User: user, Source: AUDIT_SOURCE_API, Place: place, Previous: previous.Value, State: value,
+		Message: request.Message, Until: request.Until, RemoteIp: c.ClientIP()}
+
+	// the note must exist before the new state arrives via mqtt
+	previousNote := api.notes.get(place)
+	note := &stateNote{Value: value, Message: request.Message, Until: request.Until, ChangedBy: user}
+	api.notes.set(place, note)
+	if err := api.publisher.PublishOpenState(place, value); err != nil {
+		// the state didn't change, thus the previous note and its until time are still valid
+		api.notes.set(place, previousNote)
+		if previousNote != nil && previousNote.Until != nil {
+			api.closeAt(place, previousNote, *previousNote.Until)
+		}
+		entry.Error = err.Error()
+		api.audit.record(entry)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+	api.audit.record(entry)
+	if request.Until != nil {
+		api.closeAt(place, note, *request.Until)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"place":     place,
+		"state":     value,
+		"previous":  entry.Previous,
+		"message":   note.Message,
+		"until":     note.Until,
+		"changedBy": user,
+	})
+}
+
+// returns the user of the token, every token is compared to avoid timing differences
+func (api *stateApi) authenticate(header string) (string, bool) {
+	const prefix = "Bearer "
+	if !strings.HasPrefix(header, prefix) {
+		return "", false
+	}
+	token := []byte(strings.TrimSpace(header[len(prefix):]))
+
+	user, found := "", false
+	for validToken, validUser := range api.users {
+		if subtle.ConstantTimeCompare(token, []byte(validToken)) == 1 {
+			user, found = validUser, true
+		}
+	}
+	return user, found
+}
+
+// closes the place at until, if the note is still the current one and belongs to the current state
+func (api *stateApi) closeAt(place state.Place, note *stateNote, until time.Time) {
+	api.notes.lock.Lock()
+	defer api.notes.lock.Unlock()
+	if api.notes.notes[place] != note {
+		return
+	}
+
+	note.timer = time.AfterFunc(time.Until(until), func() {
+		if api.notes.get(place) != note {
+			return
+		}
+		api.notes.set(place, nil)
+		// e.g. a note restored after a restart while the state was changed by someone else
+		if current, err := api.appState.Open.ForPlace(place); err != nil || current.Value != note.Value {
+			return
+		}
+
+		entry := auditEntry{User: note.ChangedBy, Source: AUDIT_SOURCE_UNTIL, Place: place, Previous: note.Value,
+			State: state.NONE}
+		if err := api.publisher.PublishOpenState(place, state.NONE); err != nil {
+			entry.Error = err.Error()
+		}
+		api.audit.record(entry)
+	})
+}
+
+func validateStateRequest(request stateRequest, now time.Time) (state.OpenValue, error) {
+	value, err := parseSwitchValue(request.State)
+	if err != nil {
+		return "", err
+	}
+	if utf8.RuneCountInString(request.Message) > MAX_STATE_MESSAGE_LENGTH {
+		return "", errors.New("The message is too long.")
+	}
+	if request.Until != nil {
+		if value == state.NONE {
+			return "", errors.New("The until time is only valid for an open state.")
+		}
+		if !request.Until.After(now) {
+			return "", errors.New("The until time must be in the future.")
+		}
+	}
+
+	return value, nil
+}
+
+func loadStateNotes(filename string) (map[state.Place]*stateNote, error) {
+	notes := make(map[state.Place]*stateNote)
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return notes, nil
+	}
+	if err != nil {
+		return notes, err
+	}
+	if err := json.Unmarshal(data, &notes); err != nil {
+		return make(map[state.Place]*stateNote), err
+	}
+	return notes, nil
+}
+
+// replaces the file atomically
+func writeStateNotes(filename string, notes map[state.Place]*stateNote) error {
+	data, err := json.Marshal(notes)
+	if err != nil {
+		return err
+	}
+	tmpFile, err := ioutil.TempFile(filepath.Dir(filename), ".notes")
+	if err != nil {
+		return err
+	}
+	if _, err := tmpFile.Write(data); err != nil {
+		tmpFile.Close()
+		os.Remove(tmpFile.Name())
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		os.Remove(tmpFile.Name())
+		return err
+	}
+	return os.Rename(tmpFile.Name(), filename)
+}
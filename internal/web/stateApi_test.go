@@ -0,0 +1,263 @@
+package web
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ktt-ol/status2/internal/events"
+	"github.com/ktt-ol/status2/internal/state"
+	"github.com/stretchr/testify/require"
+)
+
+// sets the state directly, like the mqtt roundtrip
+type fakePublisher struct {
+	lock      sync.Mutex
+	appState  *state.State
+	ev        events.EventManager
+	published []state.OpenValue
+	err       error
+}
+
+func (p *fakePublisher) PublishOpenState(place state.Place, value state.OpenValue) error {
+	p.lock.Lock()
+	if p.err != nil {
+		p.lock.Unlock()
+		return p.err
+	}
+	p.published = append(p.published, value)
+	current, _ := p.appState.Open.ForPlace(place)
+	*current = state.OpenValueTs{Value: value, Timestamp: time.Now().Unix()}
+	p.lock.Unlock()
+
+	event, _ := place.OpenStateEvent()
+	p.ev.Emit(event)
+	return nil
+}
+
+func (p *fakePublisher) publishedValues() []state.OpenValue {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return append([]state.OpenValue(nil), p.published...)
+}
+
+type stateApiTest struct {
+	router    *gin.Engine
+	appState  *state.State
+	publisher *fakePublisher
+	notes     *stateNotes
+	auditFile string
+}
+
+func newStateApiTest(t *testing.T, dir string) *stateApiTest {
+	gin.SetMode(gin.TestMode)
+	test := &stateApiTest{router: gin.New(), appState: state.NewDefaultState(),
+		notes: newStateNotes(filepath.Join(dir, "notes.json")), auditFile: filepath.Join(dir, "audit.jsonl")}
+	ev := events.NewEventManager()
+	test.publisher = &fakePublisher{appState: test.appState, ev: ev}
+	StateApi(map[string]string{"hans": "token-hans", "nobody": ""}, test.appState, ev, test.publisher, test.notes,
+		newAuditLog(test.auditFile), test.router.Group("/api/state"))
+	return test
+}
+
+func (test *stateApiTest) post(place string, token string, body string) (int, map[string]interface{}) {
+	request := httptest.NewRequest(http.MethodPost, "/api/state/"+place, strings.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	recorder := httptest.NewRecorder()
+	test.router.ServeHTTP(recorder, request)
+
+	var result map[string]interface{}
+	json.Unmarshal(recorder.Body.Bytes(), &result)
+	return recorder.Code, result
+}
+
+func (test *stateApiTest) auditEntries(t *testing.T) []auditEntry {
+	file, err := os.Open(test.auditFile)
+	require.Nil(t, err)
+	defer file.Close()
+
+	var entries []auditEntry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry auditEntry
+		require.Nil(t, json.Unmarshal(scanner.Bytes(), &entry))
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func Test_StateApi(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stateApi")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	test := newStateApiTest(t, dir)
+
+	code, _ := test.post("radstelle", "", `{"state":"open"}`)
+	require.Equal(t, http.StatusUnauthorized, code)
+	code, _ = test.post("radstelle", "token-other", `{"state":"open"}`)
+	require.Equal(t, http.StatusUnauthorized, code)
+	require.Empty(t, test.publisher.publishedValues())
+
+	code, _ = test.post("kitchen", "token-hans", `{"state":"open"}`)
+	require.Equal(t, http.StatusBadRequest, code)
+	code, _ = test.post("radstelle", "token-hans", `{"state":"closing"}`)
+	require.Equal(t, http.StatusBadRequest, code)
+	code, _ = test.post("radstelle", "token-hans", `{"state":`)
+	require.Equal(t, http.StatusBadRequest, code)
+
+	code, result := test.post("radstelle", "token-hans", `{"state":"open","message":"Reparaturcafé"}`)
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, "radstelle", result["place"])
+	require.Equal(t, "open", result["state"])
+	require.Equal(t, "none", result["previous"])
+	require.Equal(t, "Reparaturcafé", result["message"])
+	require.Equal(t, "hans", result["changedBy"])
+	require.Equal(t, state.OPEN, test.appState.Open.Radstelle.Value)
+	require.Equal(t, "Reparaturcafé", test.notes.message(state.PLACE_RADSTELLE))
+
+	// another state drops the message
+	test.publisher.PublishOpenState(state.PLACE_RADSTELLE, state.KEYHOLDER)
+	require.Equal(t, "", test.notes.message(state.PLACE_RADSTELLE))
+
+	test.publisher.err = errors.New("mqtt down")
+	code, _ = test.post("space", "token-hans", `{"state":"member"}`)
+	require.Equal(t, http.StatusServiceUnavailable, code)
+	require.Nil(t, test.notes.get(state.PLACE_SPACE))
+
+	entries := test.auditEntries(t)
+	require.Len(t, entries, 2)
+	require.Equal(t, "hans", entries[0].User)
+	require.Equal(t, AUDIT_SOURCE_API, entries[0].Source)
+	require.Equal(t, state.PLACE_RADSTELLE, entries[0].Place)
+	require.Equal(t, state.NONE, entries[0].Previous)
+	require.Equal(t, state.OPEN, entries[0].State)
+	require.Equal(t, "Reparaturcafé", entries[0].Message)
+	require.Equal(t, "", entries[0].Error)
+	require.Equal(t, "mqtt down", entries[1].Error)
+}
+
+func Test_StateApi_until(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stateApi")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	test := newStateApiTest(t, dir)
+
+	until := time.Now().Add(200 * time.Millisecond).Format(time.RFC3339Nano)
+	code, _ := test.post("lab3d", "token-hans", `{"state":"open+","until":"`+until+`"}`)
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, state.OPEN_PLUS, test.appState.Open.Lab3d.Value)
+
+	for i := 0; i < 50 && len(test.publisher.publishedValues()) < 2; i++ {
+		time.Sleep(20 * time.Millisecond)
+	}
+	require.Equal(t, []state.OpenValue{state.OPEN_PLUS, state.NONE}, test.publisher.publishedValues())
+	require.Nil(t, test.notes.get(state.PLACE_LAB3D))
+
+	entries := test.auditEntries(t)
+	require.Len(t, entries, 2)
+	require.Equal(t, AUDIT_SOURCE_UNTIL, entries[1].Source)
+	require.Equal(t, "hans", entries[1].User)
+	require.Equal(t, state.NONE, entries[1].State)
+}
+
+func Test_StateApi_untilReplaced(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stateApi")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	test := newStateApiTest(t, dir)
+
+	until := time.Now().Add(100 * time.Millisecond).Format(time.RFC3339Nano)
+	code, _ := test.post("lab3d", "token-hans", `{"state":"open","until":"`+until+`"}`)
+	require.Equal(t, http.StatusOK, code)
+	// the new state has no until time
+	code, _ = test.post("lab3d", "token-hans", `{"state":"open"}`)
+	require.Equal(t, http.StatusOK, code)
+
+	time.Sleep(300 * time.Millisecond)
+	require.Equal(t, []state.OpenValue{state.OPEN, state.OPEN}, test.publisher.publishedValues())
+}
+
+// a failed publish keeps the note and the until time of the current state
+func Test_StateApi_publishFailed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stateApi")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	test := newStateApiTest(t, dir)
+
+	until := time.Now().Add(300 * time.Millisecond).Format(time.RFC3339Nano)
+	code, _ := test.post("lab3d", "token-hans", `{"state":"open","message":"Druckertag","until":"`+until+`"}`)
+	require.Equal(t, http.StatusOK, code)
+
+	test.publisher.lock.Lock()
+	test.publisher.err = errors.New("mqtt down")
+	test.publisher.lock.Unlock()
+	code, _ = test.post("lab3d", "token-hans", `{"state":"member","message":"other"}`)
+	require.Equal(t, http.StatusServiceUnavailable, code)
+	require.Equal(t, "Druckertag", test.notes.message(state.PLACE_LAB3D))
+
+	test.publisher.lock.Lock()
+	test.publisher.err = nil
+	test.publisher.lock.Unlock()
+	for i := 0; i < 50 && len(test.publisher.publishedValues()) < 2; i++ {
+		time.Sleep(20 * time.Millisecond)
+	}
+	require.Equal(t, []state.OpenValue{state.OPEN, state.NONE}, test.publisher.publishedValues())
+}
+
+// the until times are restored after a restart
+func Test_StateApi_restart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stateApi")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	test := newStateApiTest(t, dir)
+
+	until := time.Now().Add(time.Hour)
+	code, _ := test.post("radstelle", "token-hans", `{"state":"open","message":"Reparaturcafé","until":"`+
+		until.Format(time.RFC3339Nano)+`"}`)
+	require.Equal(t, http.StatusOK, code)
+
+	notes := newStateNotes(filepath.Join(dir, "notes.json"))
+	restored := notes.get(state.PLACE_RADSTELLE)
+	require.NotNil(t, restored)
+	require.Equal(t, state.OPEN, restored.Value)
+	require.Equal(t, "Reparaturcafé", restored.Message)
+	require.Equal(t, "hans", restored.ChangedBy)
+	require.True(t, until.Equal(*restored.Until))
+	require.Len(t, notes.withUntil(), 1)
+
+	// the state changed while the service was down, the note is dropped with the next state event
+	test.publisher.PublishOpenState(state.PLACE_RADSTELLE, state.NONE)
+	require.Nil(t, newStateNotes(filepath.Join(dir, "notes.json")).get(state.PLACE_RADSTELLE))
+}
+
+func Test_validateStateRequest(t *testing.T) {
+	now := time.Now()
+	past := now.Add(-time.Minute)
+	future := now.Add(time.Hour)
+
+	value, err := validateStateRequest(stateRequest{State: "keyholder", Until: &future}, now)
+	require.Nil(t, err)
+	require.Equal(t, state.KEYHOLDER, value)
+
+	_, err = validateStateRequest(stateRequest{State: "open", Until: &past}, now)
+	require.EqualError(t, err, "The until time must be in the future.")
+	_, err = validateStateRequest(stateRequest{State: "none", Until: &future}, now)
+	require.NotNil(t, err)
+	_, err = validateStateRequest(stateRequest{State: "open", Message: strings.Repeat("ä", MAX_STATE_MESSAGE_LENGTH+1)}, now)
+	require.EqualError(t, err, "The message is too long.")
+	_, err = validateStateRequest(stateRequest{State: "closed"}, now)
+	require.NotNil(t, err)
+}
@@ -6,6 +6,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/ktt-ol/status2/internal/events"
+	"github.com/ktt-ol/status2/internal/metrics"
 	"github.com/ktt-ol/status2/internal/state"
 )
 
@@ -20,6 +21,8 @@ func StatusStream(ev events.EventManager, appState *state.State, group *gin.Rout
 
 		// the default gin logger logs only at the request END, but this request is a stream
 		logger.Debug("Starting statusStream: ", c.ClientIP(), " | ", c.Request.URL.RawQuery)
+		metrics.SseClients.Inc()
+		defer metrics.SseClients.Dec()
 
 		// a small buffer to avoid getting the warning too early
 		msgChannel := make(chan ssEvent, 5)
@@ -60,13 +63,13 @@ func StatusStream(ev events.EventManager, appState *state.State, group *gin.Rout
 			})
 
 			sendAndRegister(events.TOPIC_KEYHOLDER, func() interface{} {
-				return appState.Open.Keyholder
+				return appState.Open.Keyholder.Public
 			})
 			sendAndRegister(events.TOPIC_KEYHOLDER_MACHINING, func() interface{} {
-				return appState.Open.KeyholderMachining
+				return appState.Open.KeyholderMachining.Public
 			})
 			sendAndRegister(events.TOPIC_KEYHOLDER_WOODWORKING, func() interface{} {
-				return appState.Open.KeyholderWoodworking
+				return appState.Open.KeyholderWoodworking.Public
 			})
 
 			sendAndRegister(events.TOPIC_SPACE_OPEN_STATE, func() interface{} {
@@ -5,10 +5,54 @@ import (
 	"github.com/ktt-ol/status2/internal/mqtt"
 	"net/http"
 	"github.com/ktt-ol/status2/internal/conf"
+	"github.com/ktt-ol/status2/internal/metrics"
 	"github.com/ktt-ol/status2/internal/state"
+	"math"
+	"strconv"
+	"strings"
+	"errors"
+	"time"
 )
 
-func SwitchPage(conf conf.WebServiceConf, mqttMgr *mqtt.MqttManager, group *gin.RouterGroup) {
+// the result label of the switch login metric
+const (
+	LOGIN_OK        = "ok"
+	LOGIN_FAILED    = "failed"
+	LOGIN_THROTTLED = "throttled"
+)
+
+type switchPlace struct {
+	Place   state.Place
+	Title   string
+	Current state.OpenValue
+}
+
+var switchPlaceTitles = map[state.Place]string{
+	state.PLACE_SPACE:       "Space",
+	state.PLACE_RADSTELLE:   "Radstelle",
+	state.PLACE_LAB3D:       "3D-Lab",
+	state.PLACE_MACHINING:   "Machining",
+	state.PLACE_WOODWORKING: "Woodworking",
+}
+
+type switchValue struct {
+	Value state.OpenValue
+	Label string
+	// the bootstrap button class
+	Class string
+}
+
+// the values that can be switched, the closing state is calculated
+var switchValues = [...]switchValue{
+	{state.NONE, "Geschlossen", "btn-danger"},
+	{state.KEYHOLDER, "Keyholder", "btn-warning"},
+	{state.MEMBER, "Member", "btn-warning"},
+	{state.OPEN, "Offen", "btn-success"},
+	{state.OPEN_PLUS, "Offen+", "btn-success"},
+}
+
+func SwitchPage(conf conf.WebServiceConf, appState *state.State, mqttMgr *mqtt.MqttManager, notes *stateNotes,
+	audit *auditLog, throttle *loginThrottle, group *gin.RouterGroup) {
 
 	if conf.SwitchPassword == "" {
 		logger.Info("/switch page is disabled, because no password is set.")
@@ -22,34 +66,168 @@ func SwitchPage(conf conf.WebServiceConf, mqttMgr *mqtt.MqttManager, group *gin.
 		c.HTML(http.StatusOK, "switch.html", gin.H{
 			"password":    password,
 			"showPwField": showPwField,
+			"wrongPw":     c.Query("wrongPw") == "1",
+			"throttled":   c.Query("throttled") == "1",
+			"failed":      c.Query("failed") == "1",
+			"places":      getSwitchPlaces(appState),
+			"values":      switchValues,
 		})
 	})
 
 	group.POST("", func(c *gin.Context) {
-		password := c.Query("password")
-		if password == "" {
-			password = c.PostForm("password")
+		if ok, retryAfter := checkSwitchPassword(conf, throttle, c); retryAfter > 0 {
+			redirectWithFlag(c, "throttled")
+			return
+		} else if !ok {
+			redirectWithFlag(c, "wrongPw")
+			return
 		}
 
-		if password != conf.SwitchPassword {
-			logger.WithField("tried", password).Warn("Invalid switch password!")
-			query := c.Request.URL.Query()
-			query.Set("wrongPw", "1")
-			c.Request.URL.RawQuery = query.Encode()
-			c.Redirect(http.StatusSeeOther, c.Request.URL.String())
+		place, value, err := parseSwitchAction(c.PostForm("action"))
+		if err == nil {
+			err = switchOpenState(appState, mqttMgr, notes, audit, place, value,
+				throttleClientIp(c, conf.TrustedProxies))
+		}
+		if err != nil {
+			logger.WithError(err).Warn("Can't switch the open state.")
+			redirectWithFlag(c, "failed")
 			return
 		}
 
-		action := c.PostForm("action")
+		c.Redirect(http.StatusSeeOther, c.Request.RequestURI)
+	})
 
-		if action == "open" {
-			mqttMgr.SendNewSpaceStatus(state.OPEN)
-		} else {
-			mqttMgr.SendNewSpaceStatus(state.NONE)
+	// for scripts, e.g. curl -d password=... -d state=open /switch/radstelle
+	group.POST("/:place", func(c *gin.Context) {
+		if ok, retryAfter := checkSwitchPassword(conf, throttle, c); retryAfter > 0 {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many wrong passwords, try again later."})
+			return
+		} else if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid password."})
+			return
+		}
+
+		place, err := state.ParsePlace(c.Param("place"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		value, err := parseSwitchValue(formOrQuery(c, "state"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
 		}
 
+		remoteIp := throttleClientIp(c, conf.TrustedProxies)
+		if err := switchOpenState(appState, mqttMgr, notes, audit, place, value, remoteIp); err != nil {
+			logger.WithError(err).Warn("Can't switch the open state.")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
 
-		c.Redirect(http.StatusSeeOther, c.Request.RequestURI)
+		c.JSON(http.StatusOK, gin.H{"place": place, "state": value})
 	})
+}
+
+// Wrong passwords lock out the client and, from many clients, slow down every attempt, see loginThrottle. Returns the
+// time until the client may try again if it's locked out, the password is not checked then.
+func checkSwitchPassword(conf conf.WebServiceConf, throttle *loginThrottle, c *gin.Context) (bool, time.Duration) {
+	ip := throttleClientIp(c, conf.TrustedProxies)
+	now := time.Now()
+	retryAfter, delay := throttle.attempt(ip, now)
+	if retryAfter > 0 {
+		metrics.SwitchLogins.Inc(LOGIN_THROTTLED)
+		logger.WithField("ip", ip).WithField("retryAfter", retryAfter).Warn("Switch password attempt throttled.")
+		return false, retryAfter
+	}
+	time.Sleep(delay)
 
+	if !passwordEquals(formOrQuery(c, "password"), conf.SwitchPassword) {
+		metrics.SwitchLogins.Inc(LOGIN_FAILED)
+		logger.WithField("ip", ip).Warn("Invalid switch password!")
+		return false, 0
+	}
+
+	throttle.success(ip, now)
+	metrics.SwitchLogins.Inc(LOGIN_OK)
+	return true, 0
+}
+
+// the note of a state set via the api doesn't belong to the switched state
+func switchOpenState(appState *state.State, publisher openStatePublisher, notes *stateNotes, audit *auditLog,
+	place state.Place, value state.OpenValue, remoteIp string) error {
+	previous, err := appState.Open.ForPlace(place)
+	if err != nil {
+		return err
+	}
+
+	notes.set(place, nil)
+	entry := auditEntry{User: AUDIT_SOURCE_SWITCH, Source: AUDIT_SOURCE_SWITCH, Place: place, Previous: previous.Value,
+		State: value, RemoteIp: remoteIp}
+	err = publisher.PublishOpenState(place, value)
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	audit.record(entry)
+
+	return err
+}
+
+func formOrQuery(c *gin.Context, key string) string {
+	value := c.Query(key)
+	if value == "" {
+		value = c.PostForm(key)
+	}
+	return value
+}
+
+func redirectWithFlag(c *gin.Context, flag string) {
+	query := c.Request.URL.Query()
+	query.Set(flag, "1")
+	c.Request.URL.RawQuery = query.Encode()
+	c.Redirect(http.StatusSeeOther, c.Request.URL.String())
+}
+
+func getSwitchPlaces(appState *state.State) []switchPlace {
+	places := make([]switchPlace, 0, len(state.AllPlaces))
+	for _, place := range state.AllPlaces {
+		current, _ := appState.Open.ForPlace(place)
+		places = append(places, switchPlace{place, switchPlaceTitles[place], current.Value})
+	}
+	return places
+}
+
+// the action is "<place>:<value>", e.g. "radstelle:open". The old "open" and "close" actions are for the space.
+func parseSwitchAction(action string) (state.Place, state.OpenValue, error) {
+	switch action {
+	case "open":
+		return state.PLACE_SPACE, state.OPEN, nil
+	case "close":
+		return state.PLACE_SPACE, state.NONE, nil
+	}
+
+	parts := strings.SplitN(action, ":", 2)
+	if len(parts) != 2 {
+		return "", "", errors.New("Invalid switch action: " + action)
+	}
+	place, err := state.ParsePlace(parts[0])
+	if err != nil {
+		return "", "", err
+	}
+	value, err := parseSwitchValue(parts[1])
+	if err != nil {
+		return "", "", err
+	}
+
+	return place, value, nil
+}
+
+func parseSwitchValue(value string) (state.OpenValue, error) {
+	for _, valid := range switchValues {
+		if string(valid.Value) == value {
+			return valid.Value, nil
+		}
+	}
+	return "", errors.New("Invalid switch value: " + value)
 }
@@ -0,0 +1,34 @@
+package web
+
+import (
+	"testing"
+
+	"github.com/ktt-ol/status2/internal/state"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseSwitchAction(t *testing.T) {
+	// the old actions
+	place, value, err := parseSwitchAction("open")
+	require.Nil(t, err)
+	require.Equal(t, state.PLACE_SPACE, place)
+	require.Equal(t, state.OPEN, value)
+	place, value, err = parseSwitchAction("close")
+	require.Nil(t, err)
+	require.Equal(t, state.PLACE_SPACE, place)
+	require.Equal(t, state.NONE, value)
+
+	place, value, err = parseSwitchAction("radstelle:open+")
+	require.Nil(t, err)
+	require.Equal(t, state.PLACE_RADSTELLE, place)
+	require.Equal(t, state.OPEN_PLUS, value)
+	place, value, err = parseSwitchAction("woodworking:keyholder")
+	require.Nil(t, err)
+	require.Equal(t, state.PLACE_WOODWORKING, place)
+	require.Equal(t, state.KEYHOLDER, value)
+
+	for _, invalid := range []string{"", "radstelle", "radstelle:", "kitchen:open", "space:closing", "space:closed"} {
+		_, _, err = parseSwitchAction(invalid)
+		require.NotNil(t, err, invalid)
+	}
+}
@@ -0,0 +1,16 @@
+#!/bin/sh
+# Vendors the official SpaceAPI schemas of the given upstream commit, e.g. ./update.sh <commit>
+set -e
+
+COMMIT=$1
+if [ -z "$COMMIT" ]; then
+  echo "usage: $0 <commit of https://github.com/SpaceApi/schema>" >&2
+  exit 1
+fi
+
+cd "$(dirname "$0")"
+for version in 14 15; do
+  curl -fsSL "https://raw.githubusercontent.com/SpaceApi/schema/${COMMIT}/${version}.json" -o "${version}.json"
+done
+echo "$COMMIT" > UPSTREAM_COMMIT
+echo "Vendored the schemas of SpaceApi/schema@${COMMIT}."
@@ -9,13 +9,15 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/ktt-ol/status2/internal/db"
 	"os"
+	"net"
+	"net/http"
 	"github.com/ktt-ol/status2/internal/mqtt"
 	"github.com/gin-contrib/cors"
 )
 
 var logger = logrus.WithField("where", "web")
 
-func StartWebService(conf conf.WebServiceConf, ev events.EventManager, appState *state.State, dbMgr db.DbManager, mqttMgr *mqtt.MqttManager) {
+func StartWebService(conf conf.WebServiceConf, energyConf conf.EnergyConf, forecastConf conf.ForecastConf, ev events.EventManager, appState *state.State, dbMgr db.DbManager, mqttMgr *mqtt.MqttManager) {
 	// our default is "release"
 	if os.Getenv("GIN_MODE") != "debug" {
 		gin.SetMode(gin.ReleaseMode)
@@ -34,11 +36,27 @@ func StartWebService(conf conf.WebServiceConf, ev events.EventManager, appState
 
 	api := router.Group("/api")
 	StatusStream(ev, appState, api.Group("/statusStream"))
-	SpaceInfo(appState, api.Group("/spaceInfo"))
+	forecaster := newOpenForecaster(forecastConf, dbMgr)
+	forecaster.startTimer()
+	notes := newStateNotes(conf.StateNotesFile)
+	audit := newAuditLog(conf.AuditLogFile)
+	SpaceInfo(appState, forecaster, notes, api.Group("/spaceInfo"))
 	OpenState(appState, api.Group("/openState"))
+	OpenStateIcs(dbMgr, api.Group("/openState.ics"))
 	OpenStatistics(dbMgr, api.Group("/openStatistics"))
+	OpenAnalytics(dbMgr, api.Group("/openAnalytics"))
+	DeviceStatistics(dbMgr, api.Group("/deviceStatistics"))
+	Power(dbMgr, api.Group("/power"))
+	Energy(energyConf, dbMgr, api.Group("/energy"))
+	OpenForecast(forecaster, api.Group("/openForecast"))
+	StateApi(conf.ApiTokens, appState, ev, mqttMgr, notes, audit, api.Group("/state"))
 
-	SwitchPage(conf, mqttMgr, router.Group("/switch"))
+	throttle := newLoginThrottle()
+	SwitchPage(conf, appState, mqttMgr, notes, audit, throttle, router.Group("/switch"))
+	checker := newHealthChecker(conf, appState, dbMgr, throttle)
+	Health(checker, router.Group("/healthz"))
+	Ready(checker, router.Group("/readyz"))
+	Metrics(appState, throttle, router.Group("/metrics"))
 
 	router.Static("/assets", "webUI/assets")
 	router.LoadHTMLGlob("webUI/templates/*.html")
@@ -46,7 +64,14 @@ func StartWebService(conf conf.WebServiceConf, ev events.EventManager, appState
 	router.StaticFile("/openStats", "webUI/assets/openStats.html")
 
 	addr := fmt.Sprintf("%s:%d", conf.Host, conf.Port)
-	err := router.Run(addr);
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		logger.Error("Can't listen on ", addr, ": ", err)
+		return
+	}
+	// systemd must not see the service as ready before the port is bound
+	startWatchdog(listener.Addr())
+	err = http.Serve(listener, router)
 	if err != nil {
 		logger.Error("gin exit", err)
 	}
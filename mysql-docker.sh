@@ -50,8 +50,8 @@ case "$1" in
     echo "$*" | docker exec -i $(current_id) mysql -u root --password="${PW}" ${DB_NAME}
     ;;
   create-schema)
-    echo "CREATE DATABASE ${DB_NAME}" | docker exec -i $(current_id) mysql -u root --password="${PW}"
-    cat db-schema.sql | docker exec -i $(current_id) mysql -u root --password="${PW}" ${DB_NAME}
+    echo "CREATE DATABASE ${DB_NAME} CHARACTER SET utf8mb4 COLLATE utf8mb4_unicode_ci" | docker exec -i $(current_id) mysql -u root --password="${PW}"
+    echo "The tables are created by status2, use autoMigrate or run: ./status2 db migrate"
     ;;
   import-testdata)
     cat test/db-testdata.sql | docker exec -i $(current_id) mysql -u root --password="${PW}" ${DB_NAME}
@@ -110,6 +110,9 @@ function initEventSource() {
         setText('energyFront', data.front.value);
         setText('energyBack', data.back.value)
         setText('energyMachining', data.machining.value)
+        setText('energyFront_unit', data.front.unit);
+        setText('energyBack_unit', data.back.unit);
+        setText('energyMachining_unit', data.machining.unit);
     });
 
 